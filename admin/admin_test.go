@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/groob/plist"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	store, pub := setup(t)
+	ctx := context.Background()
+	for _, cmd := range []mdm.CheckinCommand{
+		mustLoadCommand(t, "Authenticate"),
+		mustLoadCommand(t, "TokenUpdate"),
+		mustLoadCommand(t, "TokenUpdate"),
+	} {
+		if err := checkinWith(ctx, store, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := mustLoadCommand(t, "Authenticate")
+	other.UDID = "other-device"
+	if err := store.Authenticate(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	udid := mustLoadCommand(t, "TokenUpdate").UDID
+
+	h := MakeHTTPHandler(ctx, MakeEndpoints(NewService(store)), []string{"secret"})
+	do := func(method, path, token string, v interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if v != nil && rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatalf("%s %s: %s", method, path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			if code := do("GET", "/devices", token, nil); code != http.StatusUnauthorized {
+				t.Errorf("token %q: want status 401, have %d", token, code)
+			}
+		}
+	})
+
+	t.Run("no_tokens", func(t *testing.T) {
+		h := MakeHTTPHandler(ctx, MakeEndpoints(NewService(store)), nil)
+		req := httptest.NewRequest("GET", "/devices", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("want status 401, have %d", rec.Code)
+		}
+	})
+
+	t.Run("devices", func(t *testing.T) {
+		var resp struct{ Devices []Device }
+		if code := do("GET", "/devices", "secret", &resp); code != http.StatusOK {
+			t.Fatalf("want status 200, have %d", code)
+		}
+		if len(resp.Devices) != 2 {
+			t.Errorf("want 2 devices, have %d", len(resp.Devices))
+		}
+		if code := do("GET", "/devices?enrolled=true", "secret", &resp); code != http.StatusOK {
+			t.Fatalf("want status 200, have %d", code)
+		}
+		if len(resp.Devices) != 1 || resp.Devices[0].UDID != udid {
+			t.Errorf("want enrolled device %s, have %v", udid, resp.Devices)
+		}
+		if code := do("GET", "/devices?enrolled=maybe", "secret", nil); code != http.StatusBadRequest {
+			t.Errorf("invalid filter: want status 400, have %d", code)
+		}
+	})
+
+	t.Run("device", func(t *testing.T) {
+		var d Device
+		if code := do("GET", "/devices/"+udid, "secret", &d); code != http.StatusOK {
+			t.Fatalf("want status 200, have %d", code)
+		}
+		if !d.Enrolled || d.Token == "" || d.PushMagic == "" || d.EnrolledAt == nil {
+			t.Errorf("want enrolled device with push info, have %+v", d)
+		}
+		if code := do("GET", "/devices/unknown", "secret", nil); code != http.StatusNotFound {
+			t.Errorf("unknown device: want status 404, have %d", code)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		var types []string
+		path := "/devices/" + udid + "/events?limit=2"
+		for pages := 0; ; pages++ {
+			var page EventPage
+			if code := do("GET", path, "secret", &page); code != http.StatusOK {
+				t.Fatalf("want status 200, have %d", code)
+			}
+			for _, e := range page.Events {
+				types = append(types, e.MessageType)
+			}
+			if page.Next == "" {
+				break
+			}
+			if pages > 2 {
+				t.Fatal("too many pages")
+			}
+			path = "/devices/" + udid + "/events?limit=2&cursor=" + page.Next
+		}
+		want := []string{"Authenticate", "TokenUpdate", "TokenUpdate"}
+		if len(types) != len(want) {
+			t.Fatalf("want events %v, have %v", want, types)
+		}
+		for i := range want {
+			if types[i] != want[i] {
+				t.Errorf("want events %v, have %v", want, types)
+			}
+		}
+		for _, path := range []string{
+			"/devices/" + udid + "/events?limit=0",
+			"/devices/" + udid + "/events?cursor=bogus",
+			"/devices/" + udid + "/events?cursor=-1",
+			"/devices/" + udid + "/events?since=yesterday",
+		} {
+			if code := do("GET", path, "secret", nil); code != http.StatusBadRequest {
+				t.Errorf("%s: want status 400, have %d", path, code)
+			}
+		}
+	})
+
+	t.Run("unenroll", func(t *testing.T) {
+		if code := do("DELETE", "/devices/"+udid, "secret", nil); code != http.StatusNoContent {
+			t.Fatalf("want status 204, have %d", code)
+		}
+		var d Device
+		do("GET", "/devices/"+udid, "secret", &d)
+		if d.Enrolled || d.LastMessageType != "CheckOut" {
+			t.Errorf("want unenrolled device, have %+v", d)
+		}
+		if last := pub.topics[len(pub.topics)-1]; last != simple.CheckoutTopic {
+			t.Errorf("want CheckOut event published, have last publish to %s", last)
+		}
+		if code := do("DELETE", "/devices/unknown", "secret", nil); code != http.StatusNotFound {
+			t.Errorf("unknown device: want status 404, have %d", code)
+		}
+	})
+}
+
+func checkinWith(ctx context.Context, svc checkin.Service, cmd mdm.CheckinCommand) error {
+	switch cmd.MessageType {
+	case "Authenticate":
+		return svc.Authenticate(ctx, cmd)
+	case "TokenUpdate":
+		return svc.TokenUpdate(ctx, cmd)
+	default:
+		return svc.CheckOut(ctx, cmd)
+	}
+}
+
+// topicPublisher records the topics published to.
+type topicPublisher struct {
+	topics []string
+}
+
+func (p *topicPublisher) Publish(topic string, body []byte) error {
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func setup(t *testing.T) (*simple.CheckinService, *topicPublisher) {
+	f, _ := ioutil.TempFile("", "bolt-")
+	f.Close()
+	os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		t.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	pub := new(topicPublisher)
+	svc, err := simple.NewService(db, nil, simple.WithPublisher(pub))
+	if err != nil {
+		t.Fatalf("couldn't create service, err %s\n", err)
+	}
+	return svc, pub
+}
+
+func mustLoadCommand(t *testing.T, name string) mdm.CheckinCommand {
+	var payload mdm.CheckinCommand
+	data, err := ioutil.ReadFile("../testdata/" + name + ".plist")
+	if err != nil {
+		t.Fatalf("failed to open test file %q.plist, err: %s", name, err)
+	}
+	if err := plist.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("failed to unmarshal plist %q, err: %s", name, err)
+	}
+	return payload
+}
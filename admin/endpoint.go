@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+)
+
+// ErrUnauthorized is returned for requests without a valid token.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Endpoints collects the endpoints of the admin API.
+type Endpoints struct {
+	DevicesEndpoint  endpoint.Endpoint
+	DeviceEndpoint   endpoint.Endpoint
+	EventsEndpoint   endpoint.Endpoint
+	UnenrollEndpoint endpoint.Endpoint
+}
+
+// MakeEndpoints returns the endpoints of svc, each wrapped with the
+// middlewares, in order.
+func MakeEndpoints(svc Service, mw ...endpoint.Middleware) Endpoints {
+	wrap := func(e endpoint.Endpoint) endpoint.Endpoint {
+		for i := len(mw) - 1; i >= 0; i-- {
+			e = mw[i](e)
+		}
+		return e
+	}
+	return Endpoints{
+		DevicesEndpoint:  wrap(MakeDevicesEndpoint(svc)),
+		DeviceEndpoint:   wrap(MakeDeviceEndpoint(svc)),
+		EventsEndpoint:   wrap(MakeEventsEndpoint(svc)),
+		UnenrollEndpoint: wrap(MakeUnenrollEndpoint(svc)),
+	}
+}
+
+func MakeDevicesEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(devicesRequest)
+		devices, err := svc.Devices(ctx, req.Filter)
+		return devicesResponse{Devices: devices, Err: err}, nil
+	}
+}
+
+func MakeDeviceEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deviceRequest)
+		device, err := svc.Device(ctx, req.UDID)
+		return deviceResponse{Device: device, Err: err}, nil
+	}
+}
+
+func MakeEventsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(eventsRequest)
+		page, err := svc.Events(ctx, req.UDID, req.Filter)
+		return eventsResponse{EventPage: page, Err: err}, nil
+	}
+}
+
+func MakeUnenrollEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(deviceRequest)
+		err := svc.Unenroll(ctx, req.UDID)
+		return unenrollResponse{Err: err}, nil
+	}
+}
+
+// RequireToken returns an endpoint middleware which rejects requests with
+// ErrUnauthorized, unless the context carries one of the tokens. Without
+// tokens, every request is rejected. MakeHTTPHandler wraps its endpoints
+// with RequireToken and puts the bearer token of the request in the context.
+func RequireToken(tokens ...string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			have, _ := ctx.Value(tokenKey{}).(string)
+			var ok int
+			for _, token := range tokens {
+				ok |= subtle.ConstantTimeCompare([]byte(have), []byte(token))
+			}
+			if have == "" || ok != 1 {
+				return nil, ErrUnauthorized
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+type tokenKey struct{}
+
+type devicesRequest struct {
+	Filter DeviceFilter
+}
+
+type devicesResponse struct {
+	Devices []Device `json:"devices"`
+	Err     error    `json:"-"`
+}
+
+func (r devicesResponse) error() error { return r.Err }
+
+type deviceRequest struct {
+	UDID string
+}
+
+type deviceResponse struct {
+	*Device
+	Err error `json:"-"`
+}
+
+func (r deviceResponse) error() error { return r.Err }
+
+type eventsRequest struct {
+	UDID   string
+	Filter EventFilter
+}
+
+type eventsResponse struct {
+	*EventPage
+	Err error `json:"-"`
+}
+
+func (r eventsResponse) error() error { return r.Err }
+
+type unenrollResponse struct {
+	Err error `json:"-"`
+}
+
+func (r unenrollResponse) error() error { return r.Err }
@@ -0,0 +1,238 @@
+// Package admin provides an HTTP API for support staff to look up the
+// enrollment state and check-in history of devices, and to force-unenroll
+// them.
+package admin
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	"golang.org/x/net/context"
+)
+
+// DefaultPageSize and MaxPageSize limit the number of events returned by
+// Service.Events.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+)
+
+// ErrBadRequest is returned for invalid filters and page cursors.
+var ErrBadRequest = errors.New("bad request")
+
+// Service is the admin API.
+type Service interface {
+	// Devices returns the devices matching the filter, ordered by UDID.
+	Devices(ctx context.Context, filter DeviceFilter) ([]Device, error)
+
+	// Device returns the device with the given UDID.
+	Device(ctx context.Context, udid string) (*Device, error)
+
+	// Events returns a page of the archived events of a device, oldest
+	// first.
+	Events(ctx context.Context, udid string, filter EventFilter) (*EventPage, error)
+
+	// Unenroll force-unenrolls a device.
+	Unenroll(ctx context.Context, udid string) error
+}
+
+// Store is the enrollment state and archive read by the Service.
+// It is implemented by *simple.CheckinService.
+type Store interface {
+	Device(udid string) (*simple.Device, error)
+	ForEachDevice(fn func(*simple.Device) error) error
+	ListEvents(filter simple.EventFilter, cursor string, limit int) (*simple.EventPage, error)
+	Unenroll(ctx context.Context, udid string) error
+}
+
+// DeviceFilter selects devices. The zero value matches every device.
+type DeviceFilter struct {
+	// Enrolled matches devices which are, or are not, enrolled.
+	Enrolled *bool
+
+	SerialNumber string
+	Model        string
+	Topic        string
+
+	// SeenSince matches devices which checked in at or after the time.
+	SeenSince time.Time
+}
+
+func (f DeviceFilter) match(d *simple.Device) bool {
+	switch {
+	case f.Enrolled != nil && d.Enrolled != *f.Enrolled:
+		return false
+	case f.SerialNumber != "" && d.SerialNumber != f.SerialNumber:
+		return false
+	case f.Model != "" && d.Model != f.Model:
+		return false
+	case f.Topic != "" && d.Topic != f.Topic:
+		return false
+	case !f.SeenSince.IsZero() && d.LastSeen.Before(f.SeenSince):
+		return false
+	}
+	return true
+}
+
+// EventFilter selects and pages the events of a device.
+type EventFilter struct {
+	MessageType string
+
+	// Since is inclusive, Until is exclusive. A zero time leaves that end
+	// of the range open.
+	Since time.Time
+	Until time.Time
+
+	// Cursor is empty for the first page, and the Next cursor of the
+	// previous page otherwise.
+	Cursor string
+
+	// Limit is the page size. Defaults to DefaultPageSize, and can't be
+	// larger than MaxPageSize.
+	Limit int
+}
+
+// Device is the enrollment state and push info of a device.
+type Device struct {
+	UDID         string `json:"udid"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	ProductName  string `json:"product_name,omitempty"`
+	Model        string `json:"model,omitempty"`
+	OSVersion    string `json:"os_version,omitempty"`
+	BuildVersion string `json:"build_version,omitempty"`
+	DeviceName   string `json:"device_name,omitempty"`
+
+	Topic     string `json:"topic,omitempty"`
+	Token     string `json:"token,omitempty"`
+	PushMagic string `json:"push_magic,omitempty"`
+
+	Enrolled   bool       `json:"enrolled"`
+	EnrolledAt *time.Time `json:"enrolled_at,omitempty"`
+
+	LastMessageType string    `json:"last_message_type"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// Event is an archived check-in. Secrets sent by the device, such as the
+// UnlockToken, are left out.
+type Event struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	MessageType string    `json:"message_type"`
+	Topic       string    `json:"topic,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	Sequence    uint64    `json:"sequence,omitempty"`
+	Duplicate   bool      `json:"duplicate,omitempty"`
+	PolicyRule  string    `json:"policy_rule,omitempty"`
+}
+
+// EventPage is a page of events.
+type EventPage struct {
+	Events []Event `json:"events"`
+
+	// Next is the cursor of the next page, or empty if there are no more
+	// events.
+	Next string `json:"next,omitempty"`
+}
+
+// NewService creates an admin Service which reads from store.
+func NewService(store Store) Service {
+	return &service{store: store}
+}
+
+type service struct {
+	store Store
+}
+
+func (svc *service) Devices(ctx context.Context, filter DeviceFilter) ([]Device, error) {
+	devices := []Device{}
+	err := svc.store.ForEachDevice(func(d *simple.Device) error {
+		if filter.match(d) {
+			devices = append(devices, makeDevice(d))
+		}
+		return nil
+	})
+	return devices, err
+}
+
+func (svc *service) Device(ctx context.Context, udid string) (*Device, error) {
+	d, err := svc.store.Device(udid)
+	if err != nil {
+		return nil, err
+	}
+	device := makeDevice(d)
+	return &device, nil
+}
+
+func (svc *service) Events(ctx context.Context, udid string, filter EventFilter) (*EventPage, error) {
+	if _, err := svc.store.Device(udid); err != nil {
+		return nil, err
+	}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return nil, ErrBadRequest
+	}
+	page, err := svc.store.ListEvents(simple.EventFilter{
+		UDID:        udid,
+		MessageType: filter.MessageType,
+		Since:       filter.Since,
+		Until:       filter.Until,
+	}, filter.Cursor, limit)
+	if err == simple.ErrInvalidCursor {
+		return nil, ErrBadRequest
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := &EventPage{Events: []Event{}, Next: page.Next}
+	for _, e := range page.Events {
+		events.Events = append(events.Events, makeEvent(e))
+	}
+	return events, nil
+}
+
+func (svc *service) Unenroll(ctx context.Context, udid string) error {
+	return svc.store.Unenroll(ctx, udid)
+}
+
+func makeDevice(d *simple.Device) Device {
+	device := Device{
+		UDID:            d.UDID,
+		SerialNumber:    d.SerialNumber,
+		ProductName:     d.ProductName,
+		Model:           d.Model,
+		OSVersion:       d.OSVersion,
+		BuildVersion:    d.BuildVersion,
+		DeviceName:      d.DeviceName,
+		Topic:           d.Topic,
+		Token:           hex.EncodeToString(d.Token),
+		PushMagic:       d.PushMagic,
+		Enrolled:        d.Enrolled,
+		LastMessageType: d.LastMessageType,
+		LastSeen:        d.LastSeen,
+	}
+	if !d.EnrolledAt.IsZero() {
+		enrolledAt := d.EnrolledAt
+		device.EnrolledAt = &enrolledAt
+	}
+	return device
+}
+
+func makeEvent(e *checkin.Event) Event {
+	return Event{
+		ID:          e.ID,
+		Time:        e.Time,
+		MessageType: e.Command.MessageType,
+		Topic:       e.Command.Topic,
+		UserID:      e.Command.UserID,
+		Sequence:    e.Sequence,
+		Duplicate:   e.Duplicate,
+		PolicyRule:  e.PolicyRule,
+	}
+}
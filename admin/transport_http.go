@@ -0,0 +1,198 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/micromdm/checkin/service/simple"
+	"golang.org/x/net/context"
+)
+
+// MakeHTTPHandler returns an HTTP handler for the endpoints, with the routes
+//
+//	GET    /devices                list devices, filtered by the enrolled,
+//	                               serial_number, model, topic and
+//	                               seen_since query parameters
+//	GET    /devices/{udid}         get a device
+//	GET    /devices/{udid}/events  list the events of a device, filtered by
+//	                               the message_type, since and until query
+//	                               parameters and paged with cursor and limit
+//	DELETE /devices/{udid}         force-unenroll a device
+//
+// Times are RFC 3339. Responses are JSON.
+//
+// Every request must carry one of the tokens as the bearer token of its
+// Authorization header, and is rejected with 401 otherwise. Without tokens,
+// every request is rejected. The token is put in the context by
+// TokenToContext. A ServerBefore option passed in opts replaces it, and
+// must include TokenToContext.
+func MakeHTTPHandler(ctx context.Context, endpoints Endpoints, tokens []string, opts ...httptransport.ServerOption) http.Handler {
+	auth := RequireToken(tokens...)
+	endpoints = Endpoints{
+		DevicesEndpoint:  auth(endpoints.DevicesEndpoint),
+		DeviceEndpoint:   auth(endpoints.DeviceEndpoint),
+		EventsEndpoint:   auth(endpoints.EventsEndpoint),
+		UnenrollEndpoint: auth(endpoints.UnenrollEndpoint),
+	}
+	opts = append([]httptransport.ServerOption{
+		httptransport.ServerBefore(TokenToContext()),
+		httptransport.ServerErrorEncoder(encodeError),
+	}, opts...)
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/devices").Handler(httptransport.NewServer(
+		ctx,
+		endpoints.DevicesEndpoint,
+		decodeDevicesRequest,
+		encodeResponse,
+		opts...,
+	))
+	r.Methods("GET").Path("/devices/{udid}").Handler(httptransport.NewServer(
+		ctx,
+		endpoints.DeviceEndpoint,
+		decodeDeviceRequest,
+		encodeResponse,
+		opts...,
+	))
+	r.Methods("GET").Path("/devices/{udid}/events").Handler(httptransport.NewServer(
+		ctx,
+		endpoints.EventsEndpoint,
+		decodeEventsRequest,
+		encodeResponse,
+		opts...,
+	))
+	r.Methods("DELETE").Path("/devices/{udid}").Handler(httptransport.NewServer(
+		ctx,
+		endpoints.UnenrollEndpoint,
+		decodeDeviceRequest,
+		encodeResponse,
+		opts...,
+	))
+	return r
+}
+
+// TokenToContext returns a RequestFunc which puts the bearer token of the
+// Authorization header in the context, for RequireToken.
+func TokenToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+			return ctx
+		}
+		return context.WithValue(ctx, tokenKey{}, auth[len(prefix):])
+	}
+}
+
+func decodeDevicesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := devicesRequest{Filter: DeviceFilter{
+		SerialNumber: q.Get("serial_number"),
+		Model:        q.Get("model"),
+		Topic:        q.Get("topic"),
+	}}
+	if v := q.Get("enrolled"); v != "" {
+		enrolled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, ErrBadRequest
+		}
+		req.Filter.Enrolled = &enrolled
+	}
+	var err error
+	if req.Filter.SeenSince, err = parseTime(q, "seen_since"); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeDeviceRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return deviceRequest{UDID: mux.Vars(r)["udid"]}, nil
+}
+
+func decodeEventsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := eventsRequest{
+		UDID: mux.Vars(r)["udid"],
+		Filter: EventFilter{
+			MessageType: q.Get("message_type"),
+			Cursor:      q.Get("cursor"),
+		},
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return nil, ErrBadRequest
+		}
+		req.Filter.Limit = limit
+	}
+	var err error
+	if req.Filter.Since, err = parseTime(q, "since"); err != nil {
+		return nil, err
+	}
+	if req.Filter.Until, err = parseTime(q, "until"); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseTime parses the RFC 3339 time of a query parameter. A missing
+// parameter is the zero time.
+func parseTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, ErrBadRequest
+	}
+	return t, nil
+}
+
+type errorer interface {
+	error() error
+}
+
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+	if _, ok := response.(unenrollResponse); ok {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if e, ok := err.(httptransport.Error); ok {
+		err = e.Err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(codeFrom(err))
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+func codeFrom(err error) int {
+	switch err {
+	case ErrUnauthorized:
+		return http.StatusUnauthorized
+	case ErrBadRequest:
+		return http.StatusBadRequest
+	case simple.ErrDeviceNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -11,3 +11,21 @@ type Service interface {
 	TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error
 	CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error
 }
+
+// Middleware describes a service middleware.
+type Middleware func(Service) Service
+
+type tenantKey struct{}
+
+// NewTenantContext returns a copy of ctx which carries a tenant ID.
+// Services which host several organizations use it to tell their check-ins
+// apart.
+func NewTenantContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ID carried by ctx, if any.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
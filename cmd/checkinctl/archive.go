@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/groob/plist"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+const archiveUsage = `usage: checkinctl archive <subcommand> [<flags>]
+
+subcommands:
+    list      list archived events
+    dump      print a single event
+    tail      print new events as they are published to NSQ, or as they
+              appear in a database which no service has open
+    export    export a range of events as NDJSON or CSV
+
+The archive is opened read-only. BoltDB permits only one process to hold a
+database open for writing, so these commands wait up to -timeout for the
+Check-in service to release the file. Run them against a copy of the
+database to inspect a running service. To follow a running service, run
+tail with -nsqd, which reads the events from NSQ instead of the database.
+`
+
+func runArchive(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, archiveUsage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		return archiveList(args[1:])
+	case "dump":
+		return archiveDump(args[1:])
+	case "tail":
+		return archiveTail(args[1:])
+	case "export":
+		return archiveExport(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(archiveUsage)
+		return nil
+	default:
+		fmt.Fprintf(os.Stderr, "checkinctl archive: unknown subcommand %q\n\n%s", args[0], archiveUsage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// archiveFlags are the flags shared by all archive subcommands.
+type archiveFlags struct {
+	path    string
+	bucket  string
+	timeout time.Duration
+
+	since       string
+	until       string
+	udid        string
+	messageType string
+}
+
+func newArchiveFlagSet(name string, withRange bool) (*flag.FlagSet, *archiveFlags) {
+	var af archiveFlags
+	fs := flag.NewFlagSet("archive "+name, flag.ExitOnError)
+	fs.StringVar(&af.path, "db", "", "path to the Check-in BoltDB database")
+	fs.StringVar(&af.bucket, "bucket", simple.CheckinBucket, "name of the archive bucket")
+	fs.DurationVar(&af.timeout, "timeout", 5*time.Second, "time to wait for the database lock")
+	fs.StringVar(&af.udid, "udid", "", "only show events for this device UDID")
+	fs.StringVar(&af.messageType, "type", "", "only show events with this MessageType")
+	if withRange {
+		fs.StringVar(&af.since, "since", "", "only show events archived at or after this RFC3339 time")
+		fs.StringVar(&af.until, "until", "", "only show events archived before this RFC3339 time")
+	}
+	return fs, &af
+}
+
+func (af *archiveFlags) filter() (simple.EventFilter, error) {
+	filter := simple.EventFilter{
+		UDID:        af.udid,
+		MessageType: af.messageType,
+		Bucket:      af.bucket,
+	}
+	var err error
+	if af.since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339Nano, af.since); err != nil {
+			return filter, fmt.Errorf("parse -since: %s", err)
+		}
+	}
+	if af.until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339Nano, af.until); err != nil {
+			return filter, fmt.Errorf("parse -until: %s", err)
+		}
+	}
+	return filter, nil
+}
+
+func (af *archiveFlags) open() (*bolt.DB, error) {
+	if af.path == "" {
+		return nil, errors.New("the -db flag is required")
+	}
+	db, err := bolt.Open(af.path, 0600, &bolt.Options{ReadOnly: true, Timeout: af.timeout})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("open %s: database is locked by another process", af.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %s", af.path, err)
+	}
+	return db, nil
+}
+
+func archiveList(args []string) error {
+	fs, af := newArchiveFlagSet("list", true)
+	limit := fs.Int("limit", 0, "maximum number of events to list (0 lists all)")
+	fs.Parse(args)
+
+	filter, err := af.filter()
+	if err != nil {
+		return err
+	}
+	db, err := af.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tID\tMESSAGE TYPE\tUDID\tTOPIC")
+	var n int
+	err = simple.ForEachEvent(db, filter, func(e *checkin.Event) error {
+		printEventLine(tw, e)
+		n++
+		if *limit > 0 && n >= *limit {
+			return simple.ErrStopIteration
+		}
+		return nil
+	})
+	tw.Flush()
+	return err
+}
+
+func archiveDump(args []string) error {
+	fs, af := newArchiveFlagSet("dump", true)
+	id := fs.String("id", "", "ID of the event to print")
+	format := fs.String("format", "json", "output format: json or plist")
+	fs.Parse(args)
+
+	if *id == "" {
+		return errors.New("the -id flag is required")
+	}
+	if *format != "json" && *format != "plist" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	filter, err := af.filter()
+	if err != nil {
+		return err
+	}
+	db, err := af.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var found *checkin.Event
+	err = simple.ForEachEvent(db, filter, func(e *checkin.Event) error {
+		if e.ID == *id {
+			found = e
+			return simple.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("event %s not found", *id)
+	}
+
+	if *format == "plist" {
+		enc := plist.NewEncoder(os.Stdout)
+		enc.Indent("  ")
+		return enc.Encode(found)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(found)
+}
+
+func archiveTail(args []string) error {
+	fs, af := newArchiveFlagSet("tail", false)
+	interval := fs.Duration("interval", 2*time.Second, "how often to poll the database for new events")
+	asJSON := fs.Bool("json", false, "print events as NDJSON")
+	nsqdAddr := fs.String("nsqd", "", "read events from the nsqd at this TCP address instead of polling -db")
+	topics := fs.String("topics", strings.Join([]string{simple.AuthenticateTopic, simple.TokenUpdateTopic, simple.CheckoutTopic}, ","), "comma separated NSQ topics to read with -nsqd")
+	fs.Parse(args)
+
+	filter, err := af.filter()
+	if err != nil {
+		return err
+	}
+	filter.Since = time.Now()
+
+	show := func(e *checkin.Event) error {
+		printEventLine(os.Stdout, e)
+		return nil
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		show = func(e *checkin.Event) error { return enc.Encode(e) }
+	}
+	if *nsqdAddr != "" {
+		return tailNSQ(*nsqdAddr, strings.Split(*topics, ","), filter, show)
+	}
+
+	// BoltDB locks the file for as long as it is open, so the database
+	// can't be polled while a Check-in service has it open. Polling is for
+	// a database which is written to by a service which opens it only for
+	// short periods, or which is being copied into place.
+	for {
+		db, err := af.open()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "checkinctl: %s, retrying\n", err)
+			time.Sleep(*interval)
+			continue
+		}
+		err = simple.ForEachEvent(db, filter, func(e *checkin.Event) error {
+			if err := show(e); err != nil {
+				return err
+			}
+			filter.Since = e.Time.Add(time.Nanosecond)
+			return nil
+		})
+		db.Close()
+		if err != nil {
+			return err
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// tailNSQ shows the events published to the topics, from an ephemeral
+// channel so that the Check-in consumers are not affected, until
+// interrupted.
+func tailNSQ(addr string, topics []string, filter simple.EventFilter, show func(*checkin.Event) error) error {
+	var mu sync.Mutex
+	handler := nsq.HandlerFunc(func(m *nsq.Message) error {
+		var e checkin.Event
+		if err := checkin.UnmarshalEvent(m.Body, &e); err != nil {
+			fmt.Fprintf(os.Stderr, "checkinctl: unmarshal event: %s\n", err)
+			return nil
+		}
+		if filter.UDID != "" && e.Command.UDID != filter.UDID {
+			return nil
+		}
+		if filter.MessageType != "" && e.Command.MessageType != filter.MessageType {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := show(&e); err != nil {
+			fmt.Fprintf(os.Stderr, "checkinctl: %s\n", err)
+		}
+		return nil
+	})
+	config := nsq.NewConfig()
+	var consumers []*nsq.Consumer
+	defer func() {
+		for _, c := range consumers {
+			c.Stop()
+		}
+	}()
+	for _, topic := range topics {
+		c, err := nsq.NewConsumer(topic, "checkinctl#ephemeral", config)
+		if err != nil {
+			return fmt.Errorf("consume %s: %s", topic, err)
+		}
+		c.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+		c.AddHandler(handler)
+		if err := c.ConnectToNSQD(addr); err != nil {
+			return fmt.Errorf("connect to nsqd %s: %s", addr, err)
+		}
+		consumers = append(consumers, c)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	return nil
+}
+
+func archiveExport(args []string) error {
+	fs, af := newArchiveFlagSet("export", true)
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	output := fs.String("o", "", "write output to this file instead of stdout")
+	fs.Parse(args)
+
+	filter, err := af.filter()
+	if err != nil {
+		return err
+	}
+	var export func(io.Writer) (func(*checkin.Event) error, func() error)
+	switch *format {
+	case "ndjson":
+		export = exportNDJSON
+	case "csv":
+		export = exportCSV
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	db, err := af.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	write, flush := export(w)
+	if err := simple.ForEachEvent(db, filter, write); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func printEventLine(w io.Writer, e *checkin.Event) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+		e.Time.Format(time.RFC3339Nano),
+		e.ID,
+		e.Command.MessageType,
+		e.Command.UDID,
+		e.Command.Topic,
+	)
+}
+
+func exportNDJSON(w io.Writer) (func(*checkin.Event) error, func() error) {
+	enc := json.NewEncoder(w)
+	write := func(e *checkin.Event) error { return enc.Encode(e) }
+	flush := func() error { return nil }
+	return write, flush
+}
+
+var csvHeader = []string{
+	"id", "time", "message_type", "udid", "topic",
+	"serial_number", "product_name", "os_version", "build_version", "device_name",
+	"push_magic", "token", "awaiting_configuration", "user_id", "user_short_name",
+}
+
+func exportCSV(w io.Writer) (func(*checkin.Event) error, func() error) {
+	cw := csv.NewWriter(w)
+	headerWritten := false
+	writeHeader := func() error {
+		if headerWritten {
+			return nil
+		}
+		headerWritten = true
+		return cw.Write(csvHeader)
+	}
+	write := func(e *checkin.Event) error {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		cmd := e.Command
+		return cw.Write([]string{
+			e.ID,
+			e.Time.Format(time.RFC3339Nano),
+			cmd.MessageType,
+			cmd.UDID,
+			cmd.Topic,
+			cmd.SerialNumber,
+			cmd.ProductName,
+			cmd.OSVersion,
+			cmd.BuildVersion,
+			cmd.DeviceName,
+			cmd.PushMagic,
+			hex.EncodeToString(cmd.Token),
+			strconv.FormatBool(cmd.AwaitingConfiguration),
+			cmd.UserID,
+			cmd.UserShortName,
+		})
+	}
+	flush := func() error {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return write, flush
+}
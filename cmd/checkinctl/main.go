@@ -0,0 +1,36 @@
+// Command checkinctl is a tool for operating the MDM Check-in service.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: checkinctl <command> [<args>]
+
+commands:
+    archive    inspect the Check-in event archive
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "archive":
+		err = runArchive(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "checkinctl: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "checkinctl: %s\n", err)
+		os.Exit(1)
+	}
+}
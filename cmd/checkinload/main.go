@@ -0,0 +1,161 @@
+// Command checkinload measures how many Check-in requests a single instance
+// of the Check-in service can absorb.
+//
+// It runs the full stack in process: an HTTP server with the Check-in
+// handler, the Check-in endpoint and a simple.CheckinService archiving to
+// BoltDB. Events are published to a local stand-in instead of NSQ.
+// A fleet of simulated devices then enrolls concurrently, and the request
+// latency, throughput and BoltDB write statistics are reported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	"github.com/micromdm/checkin/simulator"
+)
+
+func main() {
+	var (
+		flDevices        = flag.Int("devices", 1000, "number of devices to enroll")
+		flConcurrency    = flag.Int("concurrency", 50, "number of devices checking in at the same time")
+		flUserChannel    = flag.Bool("user-channel", false, "send a user channel TokenUpdate from every device")
+		flDB             = flag.String("db", "", "path to the BoltDB database, a temporary file if empty")
+		flNoSync         = flag.Bool("nosync", false, "disable fsync in BoltDB, for comparison only")
+		flPublishLatency = flag.Duration("publish-latency", 0, "simulated latency of each publish")
+		flBatchSize      = flag.Int("batch-size", 0, "archive with group commits of up to this many events")
+		flBatchDelay     = flag.Duration("batch-delay", 10*time.Millisecond, "maximum time an event waits for a group commit")
+	)
+	flag.Parse()
+
+	path := *flDB
+	if path == "" {
+		dir, err := ioutil.TempDir("", "checkinload-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, "checkin.db")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	db.NoSync = *flNoSync
+
+	pub := &localPublisher{latency: *flPublishLatency}
+	opts := []simple.Option{simple.WithPublisher(pub)}
+	if *flBatchSize > 0 {
+		opts = append(opts, simple.WithBatchArchive(*flBatchSize, *flBatchDelay))
+	}
+	svc, err := simple.NewService(db, nil, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e := checkin.Endpoints{
+		CheckinEndpoint: checkin.MakeCheckinEndpoint(svc),
+	}
+	h := checkin.MakeHTTPHandlers(
+		context.Background(),
+		e,
+		httptransport.ServerErrorEncoder(checkin.EncodeError),
+	)
+	server := httptest.NewServer(h.CheckinHandler)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: *flConcurrency},
+	}
+	sim := simulator.New(server.URL, client, simulator.Config{
+		Devices:     *flDevices,
+		Concurrency: *flConcurrency,
+		UserChannel: *flUserChannel,
+		Seed:        time.Now().UnixNano(),
+	})
+
+	archivedBefore := countArchived(db)
+	before := db.Stats()
+	report := sim.Run(context.Background())
+	after := db.Stats()
+	stats := after.Sub(&before)
+	archived := countArchived(db) - archivedBefore
+
+	fmt.Printf("devices:      %d\n", report.Devices)
+	fmt.Printf("concurrency:  %d\n", *flConcurrency)
+	fmt.Printf("requests:     %d\n", report.Requests)
+	fmt.Printf("errors:       %d\n", len(report.Failures))
+	fmt.Printf("published:    %d\n", atomic.LoadInt64(&pub.n))
+	fmt.Printf("duration:     %s\n", report.Duration)
+	fmt.Printf("throughput:   %.1f req/s\n", report.Throughput())
+	fmt.Printf("latency p50:  %s\n", report.Percentile(50))
+	fmt.Printf("latency p90:  %s\n", report.Percentile(90))
+	fmt.Printf("latency p99:  %s\n", report.Percentile(99))
+	fmt.Printf("latency max:  %s\n", report.Percentile(100))
+
+	// Without -batch-size every archived event is its own write
+	// transaction, and BoltDB allows only one writer at a time. When the
+	// write time per event is close to the median request latency,
+	// requests are queueing on the database lock.
+	fmt.Printf("bolt events archived: %d\n", archived)
+	fmt.Printf("bolt pages written:   %d\n", stats.TxStats.Write)
+	fmt.Printf("bolt write time:      %s\n", stats.TxStats.WriteTime)
+	if archived > 0 {
+		fmt.Printf("bolt write time/event: %s\n", stats.TxStats.WriteTime/time.Duration(archived))
+	}
+	if report.Duration > 0 {
+		busy := float64(stats.TxStats.WriteTime) / float64(report.Duration) * 100
+		fmt.Printf("bolt writer busy:     %.1f%%\n", busy)
+	}
+
+	for i, f := range report.Failures {
+		if i == 10 {
+			fmt.Printf("... %d more failures\n", len(report.Failures)-i)
+			break
+		}
+		fmt.Printf("FAIL %s\n", f)
+	}
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// countArchived returns the number of events in the archive bucket.
+func countArchived(db *bolt.DB) int {
+	var n int
+	db.View(func(tx *bolt.Tx) error {
+		if bkt := tx.Bucket([]byte(simple.CheckinBucket)); bkt != nil {
+			n = bkt.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+// localPublisher stands in for an NSQ producer.
+type localPublisher struct {
+	latency time.Duration
+	n       int64
+}
+
+func (p *localPublisher) Publish(topic string, body []byte) error {
+	if p.latency > 0 {
+		time.Sleep(p.latency)
+	}
+	atomic.AddInt64(&p.n, 1)
+	return nil
+}
@@ -0,0 +1,70 @@
+// Command checkinsim sends MDM Check-in traffic from a fleet of simulated
+// devices to a Check-in server.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/micromdm/checkin/simulator"
+)
+
+func main() {
+	var (
+		flURL         = flag.String("url", "", "Check-in URL of the MDM server")
+		flDevices     = flag.Int("devices", 10, "number of devices to simulate")
+		flConcurrency = flag.Int("concurrency", 1, "number of devices checking in at the same time")
+		flTopic       = flag.String("topic", "", "APNs push topic, random if empty")
+		flUserChannel = flag.Bool("user-channel", false, "send a user channel TokenUpdate from every device")
+		flSign        = flag.Bool("sign", false, "sign requests with a generated identity certificate")
+		flSeed        = flag.Int64("seed", time.Now().UnixNano(), "seed for generating devices")
+		flInsecure    = flag.Bool("insecure", false, "skip TLS certificate verification")
+		flTimeout     = flag.Duration("timeout", 0, "stop the simulation after this duration")
+	)
+	flag.Parse()
+
+	if *flURL == "" {
+		fmt.Fprintln(os.Stderr, "checkinsim: the -url flag is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if *flInsecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	sim := simulator.New(*flURL, client, simulator.Config{
+		Devices:     *flDevices,
+		Concurrency: *flConcurrency,
+		Topic:       *flTopic,
+		UserChannel: *flUserChannel,
+		Sign:        *flSign,
+		Seed:        *flSeed,
+	})
+
+	ctx := context.Background()
+	if *flTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flTimeout)
+		defer cancel()
+	}
+	report := sim.Run(ctx)
+
+	for _, f := range report.Failures {
+		fmt.Fprintf(os.Stderr, "FAIL %s\n", f)
+	}
+	fmt.Printf("devices=%d requests=%d failures=%d took=%s seed=%d\n",
+		report.Devices, report.Requests, len(report.Failures), report.Duration, *flSeed)
+	if len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
@@ -27,7 +27,7 @@ func MakeCheckinEndpoint(svc Service) endpoint.Endpoint {
 		case "CheckOut":
 			err = svc.CheckOut(ctx, req.CheckinCommand)
 		default:
-			return checkinResponse{Err: errInvalidMessageType}, nil
+			return checkinResponse{Err: &Error{Kind: KindUnsupportedMessageType, Err: errInvalidMessageType}}, nil
 		}
 		if err != nil {
 			return checkinResponse{Err: err}, nil
@@ -38,6 +38,11 @@ func MakeCheckinEndpoint(svc Service) endpoint.Endpoint {
 
 type checkinRequest struct {
 	mdm.CheckinCommand
+
+	// remoteAddr is the IP address of the client, and forwardedFor the
+	// addresses of the X-Forwarded-For header, if any, in order.
+	remoteAddr   string
+	forwardedFor []string
 }
 
 type checkinResponse struct {
@@ -2,12 +2,20 @@ package checkin
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	lru "github.com/hashicorp/golang-lru"
 	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
 )
 
 // EndpointInstrumentingMiddleware returns an endpoint middleware that records
@@ -40,3 +48,176 @@ func EndpointLoggingMiddleware(logger log.Logger) endpoint.Middleware {
 		}
 	}
 }
+
+// RateLimitConfig configures EndpointRateLimitMiddleware.
+type RateLimitConfig struct {
+	// DeviceRate is the number of check-ins per second allowed for each
+	// UDID, with bursts of up to DeviceBurst check-ins. A zero rate
+	// disables the per-device limit. The burst defaults to 1.
+	DeviceRate  rate.Limit
+	DeviceBurst int
+
+	// IPRate is the number of check-ins per second allowed from each
+	// remote IP address, with bursts of up to IPBurst check-ins. A zero
+	// rate disables the per-IP limit. The burst defaults to 1.
+	IPRate  rate.Limit
+	IPBurst int
+
+	// TrustForwardedFor limits the address appended to the X-Forwarded-For
+	// header by the proxy in front of the server, which is the last one,
+	// instead of the address of the connection. Addresses before it are
+	// set by the client and are ignored. Only set it when the server is
+	// behind a proxy which appends to the header.
+	TrustForwardedFor bool
+
+	// TrustedProxies are the networks of proxies behind the one in front
+	// of the server. The addresses they appended to the X-Forwarded-For
+	// header are skipped, and the address appended by the first of them
+	// is limited instead. Requires TrustForwardedFor.
+	TrustedProxies []*net.IPNet
+
+	// MaxKeys is the number of UDIDs and of IP addresses whose limits
+	// are kept in memory. The least recently seen are forgotten first.
+	// Defaults to 10000.
+	MaxKeys int
+
+	// StatusCode is the HTTP status of throttled requests.
+	// Defaults to 429 (Too Many Requests). Use 401 (Unauthorized) for
+	// devices which don't handle a 429.
+	StatusCode int
+
+	// Throttled counts throttled requests, labeled by "limit", which is
+	// "udid" or "ip". Optional.
+	Throttled metrics.Counter
+}
+
+// EndpointRateLimitMiddleware returns an endpoint middleware which limits
+// check-ins per device and per remote IP address with token buckets.
+// Throttled requests are not passed to the service, and are encoded by
+// EncodeError with the configured status code and a Retry-After header.
+func EndpointRateLimitMiddleware(config RateLimitConfig) endpoint.Middleware {
+	if config.MaxKeys <= 0 {
+		config.MaxKeys = 10000
+	}
+	if config.StatusCode == 0 {
+		config.StatusCode = http.StatusTooManyRequests
+	}
+	if config.Throttled == nil {
+		config.Throttled = discard.NewCounter()
+	}
+	devices := newLimiters(config.DeviceRate, config.DeviceBurst, config.MaxKeys)
+	ips := newLimiters(config.IPRate, config.IPBurst, config.MaxKeys)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(checkinRequest)
+			ip := req.remoteAddr
+			if config.TrustForwardedFor {
+				ip = clientIP(req, config.TrustedProxies)
+			}
+			if wait, ok := ips.allow(ip); !ok {
+				config.Throttled.With("limit", "ip").Add(1)
+				return nil, rateLimitError{status: config.StatusCode, retryAfter: wait}
+			}
+			if wait, ok := devices.allow(req.UDID); !ok {
+				config.Throttled.With("limit", "udid").Add(1)
+				return nil, rateLimitError{status: config.StatusCode, retryAfter: wait}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// clientIP returns the address appended to the X-Forwarded-For header of
+// the request by the first proxy which is not trusted, walking the header
+// from the right. If the header is missing or the address is invalid, the
+// address of the connection is returned.
+func clientIP(req checkinRequest, trusted []*net.IPNet) string {
+	for i := len(req.forwardedFor) - 1; i >= 0; i-- {
+		ip := net.ParseIP(req.forwardedFor[i])
+		if ip == nil {
+			break
+		}
+		if i > 0 && containsIP(trusted, ip) {
+			continue
+		}
+		return ip.String()
+	}
+	return req.remoteAddr
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limiters holds a rate.Limiter for each key, evicting the least recently
+// used.
+type limiters struct {
+	limit rate.Limit
+	burst int
+
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func newLimiters(limit rate.Limit, burst, size int) *limiters {
+	if limit == 0 {
+		return nil
+	}
+	// a zero burst would throttle every request.
+	if burst < 1 {
+		burst = 1
+	}
+	cache, err := lru.New(size)
+	if err != nil {
+		panic(err) // only fails for a non-positive size
+	}
+	return &limiters{limit: limit, burst: burst, cache: cache}
+}
+
+// allow takes a token for key. If none is available, it returns the time
+// until one will be.
+func (l *limiters) allow(key string) (time.Duration, bool) {
+	if l == nil || key == "" {
+		return 0, true
+	}
+	l.mu.Lock()
+	v, ok := l.cache.Get(key)
+	if !ok {
+		v = rate.NewLimiter(l.limit, l.burst)
+		l.cache.Add(key, v)
+	}
+	l.mu.Unlock()
+
+	r := v.(*rate.Limiter).Reserve()
+	if !r.OK() {
+		return 0, false
+	}
+	if wait := r.Delay(); wait > 0 {
+		r.Cancel()
+		return wait, false
+	}
+	return 0, true
+}
+
+// rateLimitError is returned for throttled requests.
+type rateLimitError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e rateLimitError) Error() string { return "rate limit exceeded" }
+
+func (e rateLimitError) StatusCode() int { return e.status }
+
+func (e rateLimitError) Headers() http.Header {
+	if e.retryAfter <= 0 {
+		return nil
+	}
+	secs := int(math.Ceil(e.retryAfter.Seconds()))
+	return http.Header{"Retry-After": []string{strconv.Itoa(secs)}}
+}
@@ -0,0 +1,105 @@
+package checkin
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/groob/plist"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestEndpointRateLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     RateLimitConfig
+		udids      []string
+		forwarded  []string // X-Forwarded-For header of each request
+		wantStatus []int
+	}{
+		{
+			name:       "per_device",
+			config:     RateLimitConfig{DeviceRate: 0.001, DeviceBurst: 2},
+			udids:      []string{"device-a", "device-a", "device-b", "device-a"},
+			wantStatus: []int{200, 200, 200, 429},
+		},
+		{
+			name:       "per_ip",
+			config:     RateLimitConfig{IPRate: 0.001, IPBurst: 2},
+			udids:      []string{"device-a", "device-b", "device-c"},
+			wantStatus: []int{200, 200, 429},
+		},
+		{
+			name:       "unauthorized",
+			config:     RateLimitConfig{DeviceRate: 0.001, DeviceBurst: 1, StatusCode: http.StatusUnauthorized},
+			udids:      []string{"device-a", "device-a"},
+			wantStatus: []int{200, 401},
+		},
+		{
+			name:       "zero_burst",
+			config:     RateLimitConfig{DeviceRate: 0.001},
+			udids:      []string{"device-a", "device-a"},
+			wantStatus: []int{200, 429},
+		},
+		{
+			name:       "spoofed_forwarded_for",
+			config:     RateLimitConfig{IPRate: 0.001, IPBurst: 1, TrustForwardedFor: true},
+			udids:      []string{"device-a", "device-b"},
+			forwarded:  []string{"198.51.100.1, 203.0.113.9", "198.51.100.2, 203.0.113.9"},
+			wantStatus: []int{200, 429},
+		},
+		{
+			name: "trusted_proxy",
+			config: RateLimitConfig{
+				IPRate: 0.001, IPBurst: 1, TrustForwardedFor: true,
+				TrustedProxies: []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8")},
+			},
+			udids:      []string{"device-a", "device-b", "device-c"},
+			forwarded:  []string{"203.0.113.9, 10.0.0.1", "198.51.100.1, 203.0.113.9, 10.0.0.2", "203.0.113.10, 10.0.0.1"},
+			wantStatus: []int{200, 429, 200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &mock.CheckinService{TokenUpdateFunc: mock.SucceedCheckin}
+			e := EndpointRateLimitMiddleware(tt.config)(MakeCheckinEndpoint(svc))
+			h := MakeHTTPHandlers(
+				context.Background(),
+				Endpoints{CheckinEndpoint: e},
+				httptransport.ServerErrorEncoder(EncodeError),
+			)
+			for i, udid := range tt.udids {
+				buf := new(bytes.Buffer)
+				cmd := mdm.CheckinCommand{MessageType: "TokenUpdate", UDID: udid}
+				if err := plist.NewEncoder(buf).Encode(&cmd); err != nil {
+					t.Fatal(err)
+				}
+				req := httptest.NewRequest("PUT", "/checkin", buf)
+				if tt.forwarded != nil {
+					req.Header.Set("X-Forwarded-For", tt.forwarded[i])
+				}
+				rec := httptest.NewRecorder()
+				h.CheckinHandler.ServeHTTP(rec, req)
+				if want, have := tt.wantStatus[i], rec.Code; want != have {
+					t.Fatalf("request %d: want status %d, have %d", i, want, have)
+				}
+				if rec.Code != http.StatusOK && rec.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d: throttled without Retry-After header", i)
+				}
+			}
+		})
+	}
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
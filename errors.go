@@ -0,0 +1,127 @@
+package checkin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// Kind classifies the errors returned by a Service, so that the transport can
+// respond to each class of error differently.
+type Kind int
+
+const (
+	// KindUnauthorized is a check-in which the server refuses, for example
+	// from an unknown device. Errors without a Kind are unauthorized.
+	KindUnauthorized Kind = iota
+
+	// KindValidation is a malformed check-in request.
+	KindValidation
+
+	// KindUnsupportedMessageType is a check-in with a MessageType which the
+	// server does not implement.
+	KindUnsupportedMessageType
+
+	// KindStorageUnavailable is a check-in which could not be stored.
+	KindStorageUnavailable
+
+	// KindUpstreamUnavailable is a check-in which could not be passed on to
+	// a downstream system, such as the message queue.
+	KindUpstreamUnavailable
+
+	// KindInternal is a check-in which failed because of a fault of the
+	// server, such as a misconfiguration.
+	KindInternal
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindValidation:
+		return "validation"
+	case KindUnsupportedMessageType:
+		return "unsupported_message_type"
+	case KindStorageUnavailable:
+		return "storage_unavailable"
+	case KindUpstreamUnavailable:
+		return "upstream_unavailable"
+	case KindInternal:
+		return "internal"
+	default:
+		return "unknown"
+	}
+}
+
+// kindFromString returns the Kind named s by Kind.String.
+func kindFromString(s string) (Kind, bool) {
+	for k := KindUnauthorized; k <= KindInternal; k++ {
+		if k.String() == s {
+			return k, true
+		}
+	}
+	return KindUnauthorized, false
+}
+
+// Error is an error with a Kind. EncodeError responds to an Error with the
+// status code of its Kind:
+//
+//	KindValidation             400 Bad Request
+//	KindUnauthorized           401 Unauthorized
+//	KindUnsupportedMessageType 404 Not Found
+//	KindStorageUnavailable     503 Service Unavailable
+//	KindUpstreamUnavailable    503 Service Unavailable
+//	KindInternal               500 Internal Server Error
+type Error struct {
+	Kind Kind
+	Err  error
+
+	// RetryAfter is sent to the client in a Retry-After header, if set.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.String()
+	}
+	return e.Kind.String() + ": " + e.Err.Error()
+}
+
+// StatusCode returns the HTTP status code for the Kind of the error.
+func (e *Error) StatusCode() int {
+	switch e.Kind {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindUnsupportedMessageType:
+		return http.StatusNotFound
+	case KindStorageUnavailable, KindUpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	case KindInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// Headers returns the Retry-After header, if RetryAfter is set.
+func (e *Error) Headers() http.Header {
+	if e.RetryAfter <= 0 {
+		return nil
+	}
+	secs := int64((e.RetryAfter + time.Second - 1) / time.Second)
+	return http.Header{"Retry-After": []string{strconv.FormatInt(secs, 10)}}
+}
+
+// ErrorKind returns the Kind of err, and false if err is not an *Error.
+// Errors wrapped by the go-kit HTTP transport are unwrapped.
+func ErrorKind(err error) (Kind, bool) {
+	if e, ok := err.(httptransport.Error); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Kind, true
+	}
+	return KindUnauthorized, false
+}
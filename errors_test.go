@@ -0,0 +1,86 @@
+package checkin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+)
+
+func TestEncodeError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		retryAfter string
+	}{
+		{
+			name:       "untyped",
+			err:        errors.New("checkin failed"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "validation",
+			err:        &Error{Kind: KindValidation, Err: errors.New("bad plist")},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unauthorized",
+			err:        &Error{Kind: KindUnauthorized, Err: errors.New("unknown device")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsupported_message_type",
+			err:        &Error{Kind: KindUnsupportedMessageType, Err: errInvalidMessageType},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "storage_unavailable",
+			err:        &Error{Kind: KindStorageUnavailable, Err: errors.New("disk full")},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "upstream_unavailable",
+			err: httptransport.Error{
+				Domain: httptransport.DomainEncode,
+				Err: &Error{
+					Kind:       KindUpstreamUnavailable,
+					Err:        errors.New("nsqd unavailable"),
+					RetryAfter: 1500 * time.Millisecond,
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			retryAfter: "2",
+		},
+		{
+			name:       "internal",
+			err:        &Error{Kind: KindInternal, Err: errors.New("invalid topic template")},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			EncodeError(context.Background(), tt.err, w)
+			if w.Code != tt.wantStatus {
+				t.Errorf("want status %d, have %d", tt.wantStatus, w.Code)
+			}
+			if have := w.Header().Get("Retry-After"); have != tt.retryAfter {
+				t.Errorf("want Retry-After %q, have %q", tt.retryAfter, have)
+			}
+
+			w = httptest.NewRecorder()
+			EncodeStrictError(context.Background(), tt.err, w)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("strict: want status 401, have %d", w.Code)
+			}
+			if len(w.Header()) != 0 {
+				t.Errorf("strict: want no headers, have %v", w.Header())
+			}
+		})
+	}
+}
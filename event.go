@@ -6,6 +6,7 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/micromdm/mdm"
 	uuid "github.com/satori/go.uuid"
+	"golang.org/x/net/context"
 
 	"github.com/micromdm/checkin/internal/checkinproto"
 )
@@ -14,6 +15,23 @@ type Event struct {
 	ID      string
 	Time    time.Time
 	Command mdm.CheckinCommand
+
+	// Duplicate is set if the device already sent an identical check-in
+	// shortly before this one.
+	Duplicate bool
+
+	// Sequence orders the events of a device. It starts at 1 and is
+	// incremented for every event with the same UDID. Zero means the
+	// service did not assign a sequence number.
+	Sequence uint64
+
+	// PolicyRule is the name of the enrollment policy rule which admitted
+	// the device, if any.
+	PolicyRule string
+
+	// Trace carries the trace context of the check-in, so that consumers
+	// of the event can continue the trace. See ExtractTrace.
+	Trace map[string]string
 }
 
 // NewEvent returns an Event with a unique ID and the current time.
@@ -26,43 +44,45 @@ func NewEvent(cmd mdm.CheckinCommand) *Event {
 	return &event
 }
 
+// EventFunc modifies an Event before it is archived and published.
+type EventFunc func(*Event)
+
+type eventFuncsKey struct{}
+
+// WithEventFunc returns a copy of ctx which carries fn, in addition to any
+// EventFuncs already carried by ctx. Service middlewares use it to annotate
+// the Event created by the service they wrap.
+func WithEventFunc(ctx context.Context, fn EventFunc) context.Context {
+	fns, _ := ctx.Value(eventFuncsKey{}).([]EventFunc)
+	fns = append(fns[:len(fns):len(fns)], fn)
+	return context.WithValue(ctx, eventFuncsKey{}, fns)
+}
+
+// NewEventWithContext returns an Event like NewEvent, modified by the
+// EventFuncs carried by ctx, in the order they were added. If ctx carries a
+// span, its trace context is set on the event with InjectTrace.
+func NewEventWithContext(ctx context.Context, cmd mdm.CheckinCommand) *Event {
+	event := NewEvent(cmd)
+	fns, _ := ctx.Value(eventFuncsKey{}).([]EventFunc)
+	for _, fn := range fns {
+		fn(event)
+	}
+	// An event whose trace context can't be injected is still published,
+	// just without a trace.
+	InjectTrace(ctx, event)
+	return event
+}
+
 // MarshalEvent serializes an event to a protocol buffer wire format.
 func MarshalEvent(e *Event) ([]byte, error) {
-	command := &checkinproto.Command{
-		MessageType: e.Command.MessageType,
-		Topic:       e.Command.Topic,
-		Udid:        e.Command.UDID,
-	}
-	switch e.Command.MessageType {
-	case "Authenticate":
-		command.Authenticate = &checkinproto.Authenticate{
-			OsVersion:    e.Command.OSVersion,
-			BuildVersion: e.Command.BuildVersion,
-			SerialNumber: e.Command.SerialNumber,
-			Imei:         e.Command.IMEI,
-			Meid:         e.Command.MEID,
-			DeviceName:   e.Command.DeviceName,
-			Challenge:    e.Command.Challenge,
-			Model:        e.Command.Model,
-			ModelName:    e.Command.ModelName,
-			ProductName:  e.Command.ProductName,
-		}
-	case "TokenUpdate":
-		command.TokenUpdate = &checkinproto.TokenUpdate{
-			Token:                 e.Command.Token,
-			PushMagic:             e.Command.PushMagic,
-			UnlockToken:           e.Command.UnlockToken,
-			AwaitingConfiguration: e.Command.AwaitingConfiguration,
-			UserId:                e.Command.UserID,
-			UserLongName:          e.Command.UserLongName,
-			UserShortName:         e.Command.UserShortName,
-			NotOnConsole:          e.Command.NotOnConsole,
-		}
-	}
 	return proto.Marshal(&checkinproto.Event{
-		Id:      e.ID,
-		Time:    e.Time.UnixNano(),
-		Command: command,
+		Id:         e.ID,
+		Time:       e.Time.UnixNano(),
+		Command:    commandToProto(e.Command),
+		Duplicate:  e.Duplicate,
+		Sequence:   e.Sequence,
+		PolicyRule: e.PolicyRule,
+		Trace:      e.Trace,
 	})
 }
 
@@ -75,35 +95,81 @@ func UnmarshalEvent(data []byte, e *Event) error {
 	}
 	e.ID = pb.Id
 	e.Time = time.Unix(0, pb.Time).UTC()
+	e.Duplicate = pb.Duplicate
+	e.Sequence = pb.Sequence
+	e.PolicyRule = pb.PolicyRule
+	e.Trace = pb.Trace
 	if pb.Command == nil {
 		return nil
 	}
-	e.Command = mdm.CheckinCommand{
-		MessageType: pb.Command.MessageType,
-		Topic:       pb.Command.Topic,
-		UDID:        pb.Command.Udid,
+	e.Command = commandFromProto(pb.Command)
+	return nil
+}
+
+func commandToProto(cmd mdm.CheckinCommand) *checkinproto.Command {
+	command := &checkinproto.Command{
+		MessageType: cmd.MessageType,
+		Topic:       cmd.Topic,
+		Udid:        cmd.UDID,
 	}
-	switch pb.Command.MessageType {
+	switch cmd.MessageType {
 	case "Authenticate":
-		e.Command.OSVersion = pb.Command.Authenticate.OsVersion
-		e.Command.BuildVersion = pb.Command.Authenticate.BuildVersion
-		e.Command.SerialNumber = pb.Command.Authenticate.SerialNumber
-		e.Command.IMEI = pb.Command.Authenticate.Imei
-		e.Command.MEID = pb.Command.Authenticate.Meid
-		e.Command.DeviceName = pb.Command.Authenticate.DeviceName
-		e.Command.Challenge = pb.Command.Authenticate.Challenge
-		e.Command.Model = pb.Command.Authenticate.Model
-		e.Command.ModelName = pb.Command.Authenticate.ModelName
-		e.Command.ProductName = pb.Command.Authenticate.ProductName
+		command.Authenticate = &checkinproto.Authenticate{
+			OsVersion:    cmd.OSVersion,
+			BuildVersion: cmd.BuildVersion,
+			SerialNumber: cmd.SerialNumber,
+			Imei:         cmd.IMEI,
+			Meid:         cmd.MEID,
+			DeviceName:   cmd.DeviceName,
+			Challenge:    cmd.Challenge,
+			Model:        cmd.Model,
+			ModelName:    cmd.ModelName,
+			ProductName:  cmd.ProductName,
+		}
 	case "TokenUpdate":
-		e.Command.Token = pb.Command.TokenUpdate.Token
-		e.Command.PushMagic = pb.Command.TokenUpdate.PushMagic
-		e.Command.UnlockToken = pb.Command.TokenUpdate.UnlockToken
-		e.Command.AwaitingConfiguration = pb.Command.TokenUpdate.AwaitingConfiguration
-		e.Command.UserID = pb.Command.TokenUpdate.UserId
-		e.Command.UserLongName = pb.Command.TokenUpdate.UserLongName
-		e.Command.UserShortName = pb.Command.TokenUpdate.UserShortName
-		e.Command.NotOnConsole = pb.Command.TokenUpdate.NotOnConsole
+		command.TokenUpdate = &checkinproto.TokenUpdate{
+			Token:                 cmd.Token,
+			PushMagic:             cmd.PushMagic,
+			UnlockToken:           cmd.UnlockToken,
+			AwaitingConfiguration: cmd.AwaitingConfiguration,
+			UserId:                cmd.UserID,
+			UserLongName:          cmd.UserLongName,
+			UserShortName:         cmd.UserShortName,
+			NotOnConsole:          cmd.NotOnConsole,
+		}
 	}
-	return nil
+	return command
+}
+
+func commandFromProto(pb *checkinproto.Command) mdm.CheckinCommand {
+	cmd := mdm.CheckinCommand{
+		MessageType: pb.GetMessageType(),
+		Topic:       pb.GetTopic(),
+		UDID:        pb.GetUdid(),
+	}
+	switch cmd.MessageType {
+	case "Authenticate":
+		auth := pb.GetAuthenticate()
+		cmd.OSVersion = auth.GetOsVersion()
+		cmd.BuildVersion = auth.GetBuildVersion()
+		cmd.SerialNumber = auth.GetSerialNumber()
+		cmd.IMEI = auth.GetImei()
+		cmd.MEID = auth.GetMeid()
+		cmd.DeviceName = auth.GetDeviceName()
+		cmd.Challenge = auth.GetChallenge()
+		cmd.Model = auth.GetModel()
+		cmd.ModelName = auth.GetModelName()
+		cmd.ProductName = auth.GetProductName()
+	case "TokenUpdate":
+		update := pb.GetTokenUpdate()
+		cmd.Token = update.GetToken()
+		cmd.PushMagic = update.GetPushMagic()
+		cmd.UnlockToken = update.GetUnlockToken()
+		cmd.AwaitingConfiguration = update.GetAwaitingConfiguration()
+		cmd.UserID = update.GetUserId()
+		cmd.UserLongName = update.GetUserLongName()
+		cmd.UserShortName = update.GetUserShortName()
+		cmd.NotOnConsole = update.GetNotOnConsole()
+	}
+	return cmd
 }
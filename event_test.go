@@ -8,6 +8,7 @@ import (
 	"github.com/groob/plist"
 	"github.com/micromdm/checkin"
 	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
 )
 
 var marshalTests = []string{
@@ -46,3 +47,46 @@ func mustLoadCommand(t *testing.T, name string) mdm.CheckinCommand {
 	}
 	return payload
 }
+
+func BenchmarkMarshalEvent(b *testing.B) {
+	for _, name := range marshalTests {
+		b.Run(name, func(b *testing.B) {
+			var payload mdm.CheckinCommand
+			data, err := ioutil.ReadFile("testdata/" + name + ".plist")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := plist.Unmarshal(data, &payload); err != nil {
+				b.Fatal(err)
+			}
+			event := checkin.NewEvent(payload)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := checkin.MarshalEvent(event); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewEventWithContext(t *testing.T) {
+	var order []string
+	ctx := context.Background()
+	ctx = checkin.WithEventFunc(ctx, func(e *checkin.Event) {
+		order = append(order, "first")
+		e.Duplicate = true
+	})
+	ctx = checkin.WithEventFunc(ctx, func(e *checkin.Event) {
+		order = append(order, "second")
+	})
+
+	event := checkin.NewEventWithContext(ctx, mustLoadCommand(t, "TokenUpdate"))
+	if !event.Duplicate {
+		t.Error("EventFunc not applied")
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("EventFuncs applied out of order: %v", order)
+	}
+}
@@ -1,3 +1,3 @@
 package checkinproto
 
-//go:generate protoc --go_out=. checkin.proto
+//go:generate protoc --go_out=plugins=grpc:. checkin.proto
@@ -13,6 +13,8 @@ It has these top-level messages:
 	Command
 	Authenticate
 	TokenUpdate
+	CheckinRequest
+	CheckinResponse
 */
 package checkinproto
 
@@ -20,6 +22,11 @@ import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
 
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
 var _ = fmt.Errorf
@@ -32,9 +39,13 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Event struct {
-	Id      string   `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Time    int64    `protobuf:"varint,2,opt,name=time" json:"time,omitempty"`
-	Command *Command `protobuf:"bytes,3,opt,name=command" json:"command,omitempty"`
+	Id         string            `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Time       int64             `protobuf:"varint,2,opt,name=time" json:"time,omitempty"`
+	Command    *Command          `protobuf:"bytes,3,opt,name=command" json:"command,omitempty"`
+	Duplicate  bool              `protobuf:"varint,4,opt,name=duplicate" json:"duplicate,omitempty"`
+	Sequence   uint64            `protobuf:"varint,5,opt,name=sequence" json:"sequence,omitempty"`
+	PolicyRule string            `protobuf:"bytes,6,opt,name=policy_rule,json=policyRule" json:"policy_rule,omitempty"`
+	Trace      map[string]string `protobuf:"bytes,7,rep,name=trace" json:"trace,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *Event) Reset()                    { *m = Event{} }
@@ -63,6 +74,34 @@ func (m *Event) GetCommand() *Command {
 	return nil
 }
 
+func (m *Event) GetDuplicate() bool {
+	if m != nil {
+		return m.Duplicate
+	}
+	return false
+}
+
+func (m *Event) GetSequence() uint64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *Event) GetPolicyRule() string {
+	if m != nil {
+		return m.PolicyRule
+	}
+	return ""
+}
+
+func (m *Event) GetTrace() map[string]string {
+	if m != nil {
+		return m.Trace
+	}
+	return nil
+}
+
 type Command struct {
 	MessageType  string        `protobuf:"bytes,1,opt,name=message_type,json=messageType" json:"message_type,omitempty"`
 	Topic        string        `protobuf:"bytes,2,opt,name=topic" json:"topic,omitempty"`
@@ -271,49 +310,184 @@ func (m *TokenUpdate) GetNotOnConsole() bool {
 	return false
 }
 
+type CheckinRequest struct {
+	Command *Command `protobuf:"bytes,1,opt,name=command" json:"command,omitempty"`
+}
+
+func (m *CheckinRequest) Reset()                    { *m = CheckinRequest{} }
+func (m *CheckinRequest) String() string            { return proto.CompactTextString(m) }
+func (*CheckinRequest) ProtoMessage()               {}
+func (*CheckinRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *CheckinRequest) GetCommand() *Command {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+type CheckinResponse struct {
+	Err               string `protobuf:"bytes,1,opt,name=err" json:"err,omitempty"`
+	ErrKind           string `protobuf:"bytes,2,opt,name=err_kind,json=errKind" json:"err_kind,omitempty"`
+	RetryAfterSeconds int64  `protobuf:"varint,3,opt,name=retry_after_seconds,json=retryAfterSeconds" json:"retry_after_seconds,omitempty"`
+}
+
+func (m *CheckinResponse) Reset()                    { *m = CheckinResponse{} }
+func (m *CheckinResponse) String() string            { return proto.CompactTextString(m) }
+func (*CheckinResponse) ProtoMessage()               {}
+func (*CheckinResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *CheckinResponse) GetErr() string {
+	if m != nil {
+		return m.Err
+	}
+	return ""
+}
+
+func (m *CheckinResponse) GetErrKind() string {
+	if m != nil {
+		return m.ErrKind
+	}
+	return ""
+}
+
+func (m *CheckinResponse) GetRetryAfterSeconds() int64 {
+	if m != nil {
+		return m.RetryAfterSeconds
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Event)(nil), "checkinproto.Event")
 	proto.RegisterType((*Command)(nil), "checkinproto.Command")
 	proto.RegisterType((*Authenticate)(nil), "checkinproto.Authenticate")
 	proto.RegisterType((*TokenUpdate)(nil), "checkinproto.TokenUpdate")
+	proto.RegisterType((*CheckinRequest)(nil), "checkinproto.CheckinRequest")
+	proto.RegisterType((*CheckinResponse)(nil), "checkinproto.CheckinResponse")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion3
+
+// Client API for Checkin service
+
+type CheckinClient interface {
+	Checkin(ctx context.Context, in *CheckinRequest, opts ...grpc.CallOption) (*CheckinResponse, error)
+}
+
+type checkinClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCheckinClient(cc *grpc.ClientConn) CheckinClient {
+	return &checkinClient{cc}
+}
+
+func (c *checkinClient) Checkin(ctx context.Context, in *CheckinRequest, opts ...grpc.CallOption) (*CheckinResponse, error) {
+	out := new(CheckinResponse)
+	err := grpc.Invoke(ctx, "/checkinproto.Checkin/Checkin", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Checkin service
+
+type CheckinServer interface {
+	Checkin(context.Context, *CheckinRequest) (*CheckinResponse, error)
+}
+
+func RegisterCheckinServer(s *grpc.Server, srv CheckinServer) {
+	s.RegisterService(&_Checkin_serviceDesc, srv)
+}
+
+func _Checkin_Checkin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckinRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckinServer).Checkin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/checkinproto.Checkin/Checkin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckinServer).Checkin(ctx, req.(*CheckinRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Checkin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "checkinproto.Checkin",
+	HandlerType: (*CheckinServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Checkin",
+			Handler:    _Checkin_Checkin_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: fileDescriptor0,
 }
 
 func init() { proto.RegisterFile("checkin.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 536 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x54, 0x92, 0x6f, 0x8b, 0xd3, 0x4e,
-	0x10, 0xc7, 0x49, 0xef, 0x4f, 0x9b, 0xc9, 0xb6, 0x3f, 0x58, 0x7e, 0xa7, 0x51, 0x14, 0x7b, 0xf5,
-	0x90, 0x3e, 0xaa, 0xa0, 0xf8, 0x4c, 0x04, 0x29, 0x3e, 0x10, 0xf4, 0x84, 0xf5, 0xf4, 0x91, 0x10,
-	0xb6, 0xd9, 0x31, 0x5d, 0x9a, 0xec, 0x86, 0x64, 0x53, 0xb9, 0x97, 0xe4, 0x1b, 0xf0, 0x95, 0xf8,
-	0x82, 0x64, 0x67, 0xd3, 0xbb, 0xf4, 0xd9, 0xcc, 0x67, 0xbe, 0x33, 0xb3, 0xf3, 0x4d, 0x60, 0x9a,
-	0x6f, 0x31, 0xdf, 0x69, 0xb3, 0xaa, 0x1b, 0xeb, 0x2c, 0x67, 0x7d, 0x4a, 0xd9, 0xe2, 0x07, 0x9c,
-	0x7d, 0xd8, 0xa3, 0x71, 0x7c, 0x06, 0x23, 0xad, 0xd2, 0x68, 0x1e, 0x2d, 0x63, 0x31, 0xd2, 0x8a,
-	0x73, 0x38, 0x75, 0xba, 0xc2, 0x74, 0x34, 0x8f, 0x96, 0x27, 0x82, 0x62, 0xfe, 0x12, 0xc6, 0xb9,
-	0xad, 0x2a, 0x69, 0x54, 0x7a, 0x32, 0x8f, 0x96, 0xc9, 0xab, 0x8b, 0xd5, 0x70, 0xd8, 0x6a, 0x1d,
-	0x8a, 0xe2, 0xa0, 0x5a, 0xfc, 0x8d, 0x60, 0xdc, 0x43, 0x7e, 0x09, 0xac, 0xc2, 0xb6, 0x95, 0x05,
-	0x66, 0xee, 0xb6, 0xc6, 0x7e, 0x55, 0xd2, 0xb3, 0x9b, 0xdb, 0x1a, 0xf9, 0xff, 0x70, 0xe6, 0x6c,
-	0xad, 0x73, 0x5a, 0x1a, 0x8b, 0x90, 0xf8, 0x97, 0x74, 0x4a, 0x87, 0x95, 0xb1, 0xa0, 0x98, 0xbf,
-	0x03, 0x26, 0x3b, 0xb7, 0x45, 0xe3, 0x74, 0x2e, 0x1d, 0xa6, 0xa7, 0xf4, 0x9c, 0xc7, 0xc7, 0xcf,
-	0x79, 0x3f, 0x50, 0x88, 0x23, 0x3d, 0x7f, 0x0b, 0xcc, 0xd9, 0x1d, 0x9a, 0xac, 0xab, 0x95, 0xef,
-	0x3f, 0xa3, 0xfe, 0x47, 0xc7, 0xfd, 0x37, 0x5e, 0xf1, 0x8d, 0x04, 0x22, 0x71, 0xf7, 0xc9, 0xe2,
-	0xcf, 0x08, 0xd8, 0x70, 0x38, 0x7f, 0x0a, 0x60, 0xdb, 0x6c, 0x8f, 0x4d, 0xab, 0xad, 0xe9, 0x2f,
-	0x8b, 0x6d, 0xfb, 0x3d, 0x00, 0xfe, 0x1c, 0xa6, 0x9b, 0x4e, 0x97, 0xea, 0x4e, 0x11, 0xee, 0x63,
-	0x04, 0x0f, 0xa2, 0x4b, 0x60, 0x75, 0x63, 0x55, 0x97, 0xbb, 0xcc, 0xc8, 0x0a, 0xfb, 0x73, 0x93,
-	0x9e, 0x5d, 0xcb, 0x0a, 0xfd, 0x9c, 0x16, 0x1b, 0x2d, 0xcb, 0xcc, 0x74, 0xd5, 0x06, 0x1b, 0x3a,
-	0x3b, 0x16, 0x2c, 0xc0, 0x6b, 0x62, 0xde, 0x2e, 0x5d, 0xa1, 0xa6, 0x93, 0x62, 0x41, 0xb1, 0x67,
-	0x15, 0x6a, 0x95, 0x9e, 0x07, 0xe6, 0x63, 0xfe, 0x0c, 0x12, 0x85, 0x7b, 0x9d, 0x63, 0x58, 0x37,
-	0xa6, 0x12, 0x04, 0x44, 0xdb, 0x9e, 0x40, 0x9c, 0x6f, 0x65, 0x59, 0xa2, 0x29, 0x30, 0x9d, 0xcc,
-	0xa3, 0x25, 0x13, 0xf7, 0xc0, 0x7f, 0xab, 0xca, 0x2a, 0x2c, 0xd3, 0x38, 0x7c, 0x2b, 0x4a, 0xbc,
-	0x11, 0x14, 0x84, 0x99, 0x10, 0x8c, 0x20, 0xe2, 0x47, 0x2e, 0x7e, 0x8f, 0x20, 0x19, 0xb8, 0x1a,
-	0x3e, 0xf8, 0x0e, 0x83, 0x65, 0x4c, 0x84, 0xc4, 0x0f, 0xa9, 0xbb, 0x76, 0x9b, 0x55, 0xb2, 0xb8,
-	0xfb, 0x17, 0x62, 0x4f, 0x3e, 0x7b, 0xe0, 0x8d, 0xea, 0x4c, 0x69, 0xf3, 0x5d, 0x16, 0x7a, 0x4f,
-	0xa8, 0x37, 0x09, 0x8c, 0xa6, 0xf3, 0x37, 0xf0, 0x40, 0xfe, 0x92, 0xda, 0x69, 0x53, 0x64, 0xb9,
-	0x35, 0x3f, 0x75, 0xd1, 0x35, 0xd2, 0x79, 0xe7, 0xbd, 0x63, 0x13, 0x71, 0x71, 0xa8, 0xae, 0x87,
-	0x45, 0xfe, 0x10, 0xc6, 0x5d, 0x8b, 0x4d, 0xa6, 0x55, 0xef, 0xde, 0xb9, 0x4f, 0x3f, 0x2a, 0x7e,
-	0x05, 0x33, 0x2a, 0x94, 0xd6, 0x14, 0xe1, 0xb4, 0xe0, 0x24, 0xf3, 0xf4, 0x93, 0x35, 0x05, 0x19,
-	0xf6, 0x02, 0xfe, 0x23, 0x55, 0xbb, 0xb5, 0x8d, 0x1b, 0xba, 0x3a, 0xf5, 0xf8, 0xab, 0xa7, 0xa4,
-	0xbb, 0x82, 0x99, 0xb1, 0x2e, 0xb3, 0xc6, 0xbf, 0xad, 0xb5, 0x65, 0x70, 0x77, 0x22, 0x98, 0xb1,
-	0xee, 0x8b, 0x59, 0x07, 0xb6, 0x39, 0xa7, 0x9f, 0xf0, 0xf5, 0xbf, 0x00, 0x00, 0x00, 0xff, 0xff,
-	0xac, 0x19, 0x1f, 0x55, 0xbf, 0x03, 0x00, 0x00,
+	// 741 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0xed, 0x6a, 0x23, 0x37,
+	0x14, 0xed, 0xd8, 0x71, 0xec, 0xb9, 0x33, 0x71, 0x5a, 0xb5, 0x69, 0x27, 0x26, 0x69, 0x1d, 0x37,
+	0x14, 0xff, 0x72, 0xc1, 0x6d, 0x21, 0x94, 0x52, 0x08, 0x26, 0xd0, 0xd2, 0x36, 0x05, 0x25, 0xbb,
+	0x7f, 0x07, 0x65, 0x74, 0x63, 0x0b, 0xcf, 0x48, 0xb3, 0x1a, 0x8d, 0x17, 0x3f, 0xd1, 0xb2, 0x2f,
+	0xb0, 0x4f, 0xb2, 0x0f, 0xb4, 0x48, 0x1a, 0x7f, 0x2d, 0x2c, 0xec, 0xbf, 0x7b, 0xcf, 0x3d, 0x73,
+	0xa4, 0x73, 0x24, 0x0d, 0x9c, 0x64, 0x0b, 0xcc, 0x96, 0x42, 0x4e, 0x4a, 0xad, 0x8c, 0x22, 0x71,
+	0xd3, 0xba, 0x6e, 0xf4, 0xa6, 0x05, 0x9d, 0xbb, 0x15, 0x4a, 0x43, 0xfa, 0xd0, 0x12, 0x3c, 0x09,
+	0x86, 0xc1, 0x38, 0xa4, 0x2d, 0xc1, 0x09, 0x81, 0x23, 0x23, 0x0a, 0x4c, 0x5a, 0xc3, 0x60, 0xdc,
+	0xa6, 0xae, 0x26, 0x3f, 0x43, 0x37, 0x53, 0x45, 0xc1, 0x24, 0x4f, 0xda, 0xc3, 0x60, 0x1c, 0x4d,
+	0xcf, 0x26, 0xfb, 0x6a, 0x93, 0x99, 0x1f, 0xd2, 0x0d, 0x8b, 0x5c, 0x40, 0xc8, 0xeb, 0x32, 0x17,
+	0x19, 0x33, 0x98, 0x1c, 0x0d, 0x83, 0x71, 0x8f, 0xee, 0x00, 0x32, 0x80, 0x5e, 0x85, 0xaf, 0x6a,
+	0x94, 0x19, 0x26, 0x9d, 0x61, 0x30, 0x3e, 0xa2, 0xdb, 0x9e, 0xfc, 0x00, 0x51, 0xa9, 0x72, 0x91,
+	0xad, 0x53, 0x5d, 0xe7, 0x98, 0x1c, 0xbb, 0x7d, 0x81, 0x87, 0x68, 0x9d, 0x23, 0xf9, 0x15, 0x3a,
+	0x46, 0xb3, 0x0c, 0x93, 0xee, 0xb0, 0x3d, 0x8e, 0xa6, 0xdf, 0x1f, 0xee, 0xc4, 0x79, 0x9a, 0x3c,
+	0x5a, 0xc2, 0x9d, 0x34, 0x7a, 0x4d, 0x3d, 0x79, 0x70, 0x03, 0xb0, 0x03, 0xc9, 0x97, 0xd0, 0x5e,
+	0xe2, 0xba, 0x31, 0x6d, 0x4b, 0xf2, 0x0d, 0x74, 0x56, 0x2c, 0xaf, 0xbd, 0xed, 0x90, 0xfa, 0xe6,
+	0xf7, 0xd6, 0x4d, 0x30, 0x7a, 0x1f, 0x40, 0xb7, 0xf1, 0x47, 0xae, 0x20, 0x2e, 0xb0, 0xaa, 0xd8,
+	0x1c, 0x53, 0xb3, 0x2e, 0xb1, 0x11, 0x88, 0x1a, 0xec, 0x71, 0x5d, 0xa2, 0x15, 0x32, 0xaa, 0x14,
+	0xd9, 0x46, 0xc8, 0x35, 0x36, 0xd4, 0x9a, 0x0b, 0x9f, 0x5e, 0x48, 0x5d, 0x4d, 0xfe, 0x84, 0x98,
+	0xd5, 0x66, 0x81, 0xd2, 0xec, 0x62, 0x8a, 0xa6, 0x83, 0x43, 0x3f, 0xb7, 0x7b, 0x0c, 0x7a, 0xc0,
+	0x27, 0x7f, 0x40, 0x6c, 0xd4, 0x12, 0x65, 0x5a, 0x97, 0x9c, 0x19, 0x9f, 0x64, 0x34, 0x3d, 0x3f,
+	0xfc, 0xfe, 0xd1, 0x32, 0x5e, 0x38, 0x02, 0x8d, 0xcc, 0xae, 0x19, 0xbd, 0x6b, 0x41, 0xbc, 0x2f,
+	0x4e, 0x2e, 0x01, 0x54, 0x95, 0xae, 0x50, 0x57, 0x42, 0xc9, 0xc6, 0x59, 0xa8, 0xaa, 0x97, 0x1e,
+	0x20, 0x3f, 0xc2, 0xc9, 0x53, 0x2d, 0x72, 0xbe, 0x65, 0x78, 0x7f, 0xb1, 0x03, 0x37, 0xa4, 0x2b,
+	0x88, 0x4b, 0xad, 0x78, 0x9d, 0x99, 0x54, 0xb2, 0x02, 0x1b, 0xbb, 0x51, 0x83, 0xdd, 0xb3, 0x02,
+	0xad, 0x4e, 0x85, 0x5a, 0xb0, 0x3c, 0x95, 0x75, 0xf1, 0x84, 0xda, 0xd9, 0x0e, 0x69, 0xec, 0xc1,
+	0x7b, 0x87, 0xd9, 0xb8, 0x44, 0x81, 0xc2, 0x59, 0x0a, 0xa9, 0xab, 0x2d, 0x56, 0xa0, 0xe0, 0xcd,
+	0x8d, 0x70, 0xb5, 0xbd, 0x2c, 0x1c, 0x57, 0x22, 0x43, 0xbf, 0x5c, 0xd7, 0x8d, 0xc0, 0x43, 0x6e,
+	0xb5, 0x0b, 0x08, 0xb3, 0x05, 0xcb, 0x73, 0x94, 0x73, 0x4c, 0x7a, 0xc3, 0x60, 0x1c, 0xd3, 0x1d,
+	0x60, 0xcf, 0xaa, 0x50, 0x1c, 0xf3, 0x24, 0xf4, 0x67, 0xe5, 0x1a, 0x1b, 0x84, 0x2b, 0xbc, 0x26,
+	0xf8, 0x20, 0x1c, 0x62, 0x25, 0x47, 0x6f, 0x5b, 0x10, 0xed, 0xa5, 0xea, 0x0f, 0x7c, 0x89, 0x3e,
+	0xb2, 0x98, 0xfa, 0xc6, 0x8a, 0x94, 0x75, 0xb5, 0x48, 0x0b, 0x36, 0xdf, 0xde, 0x85, 0xd0, 0x22,
+	0xff, 0x59, 0xc0, 0x06, 0x55, 0xcb, 0x5c, 0x65, 0xcb, 0xd4, 0x7f, 0xdb, 0x76, 0xdf, 0x46, 0x1e,
+	0x73, 0xea, 0xe4, 0x37, 0xf8, 0x96, 0xbd, 0x66, 0xc2, 0x08, 0x39, 0x4f, 0x33, 0x25, 0x9f, 0xc5,
+	0xbc, 0xd6, 0xcc, 0xd8, 0xe4, 0xfd, 0x7b, 0x3a, 0xdb, 0x4c, 0x67, 0xfb, 0x43, 0xf2, 0x1d, 0x74,
+	0xeb, 0x0a, 0x75, 0x2a, 0x78, 0x93, 0xde, 0xb1, 0x6d, 0xff, 0xe6, 0xe4, 0x1a, 0xfa, 0x6e, 0x90,
+	0x2b, 0x39, 0xf7, 0xd6, 0x7c, 0x92, 0xb1, 0x45, 0xff, 0x55, 0x72, 0xee, 0x02, 0xfb, 0x09, 0x4e,
+	0x1d, 0xab, 0x5a, 0x28, 0x6d, 0xf6, 0x53, 0x3d, 0xb1, 0xf0, 0x83, 0x45, 0x1d, 0xef, 0x1a, 0xfa,
+	0x52, 0x99, 0x54, 0x49, 0xbb, 0xb7, 0x4a, 0xe5, 0x3e, 0xdd, 0x1e, 0x8d, 0xa5, 0x32, 0xff, 0xcb,
+	0x99, 0xc7, 0x46, 0xb7, 0xd0, 0x9f, 0xf9, 0xdb, 0x48, 0xed, 0xfb, 0xae, 0xcc, 0xfe, 0x9f, 0x24,
+	0xf8, 0x9c, 0x3f, 0xc9, 0x48, 0xc2, 0xe9, 0x56, 0xa2, 0x2a, 0x95, 0xac, 0xd0, 0xbe, 0x5e, 0xd4,
+	0x7a, 0xf3, 0x7a, 0x51, 0x6b, 0x72, 0x0e, 0x3d, 0xd4, 0x3a, 0x5d, 0x0a, 0xc9, 0x9b, 0xac, 0xbb,
+	0xa8, 0xf5, 0x3f, 0x42, 0x72, 0x32, 0x81, 0xaf, 0x35, 0x1a, 0xbd, 0x4e, 0xd9, 0xb3, 0xb1, 0xbe,
+	0x30, 0x53, 0x92, 0x57, 0x2e, 0xf0, 0x36, 0xfd, 0xca, 0x8d, 0x6e, 0xed, 0xe4, 0xc1, 0x0f, 0xa6,
+	0x0f, 0xd0, 0x6d, 0xd6, 0x23, 0x7f, 0xed, 0xca, 0x8b, 0x8f, 0x76, 0x79, 0x60, 0x6a, 0x70, 0xf9,
+	0x89, 0xa9, 0xdf, 0xef, 0xe8, 0x8b, 0xa7, 0x63, 0x37, 0xf8, 0xe5, 0xc3, 0x00, 0xb1, 0x58, 0x4e,
+	0xd7, 0x93, 0x05, 0x00, 0x00,
 }
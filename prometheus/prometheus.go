@@ -0,0 +1,123 @@
+// Package prometheus creates the metrics of the Check-in service and its
+// middlewares, and exposes them to Prometheus.
+package prometheus
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/micromdm/checkin/service/simple"
+)
+
+// Metrics are the metrics of the Check-in service, ready to be passed to
+// the middlewares and options which update them.
+type Metrics struct {
+	// Requests and Duration are updated by checkin.InstrumentingMiddleware.
+	Requests metrics.Counter
+	Duration metrics.Histogram
+
+	// Simple, Stats and Publish are updated by simple.CheckinService,
+	// with simple.WithMetrics, CheckinService.ReportStats and
+	// simple.WithRetry.
+	Simple  simple.Metrics
+	Stats   simple.StatsGauges
+	Publish simple.PublishMetrics
+
+	// Throttled is updated by checkin.EndpointRateLimitMiddleware.
+	Throttled metrics.Counter
+
+	// Duplicates, TopicRejected, PolicyDenied and ChallengeRejected are
+	// updated by the dedup, pushtopic, policy and challenge middlewares.
+	Duplicates        metrics.Counter
+	TopicRejected     metrics.Counter
+	PolicyDenied      metrics.Counter
+	ChallengeRejected metrics.Counter
+}
+
+// NewMetrics creates the metrics in namespace, and registers them with reg.
+func NewMetrics(namespace string, reg stdprometheus.Registerer) (*Metrics, error) {
+	r := registerer{namespace: namespace, reg: reg}
+	m := &Metrics{
+		Requests: r.counter("requests_total", "Number of check-ins received.", "message_type", "error_class"),
+		Duration: r.histogram("request_duration_seconds", "Time taken to process a check-in.", "message_type", "error_class"),
+		Simple: simple.Metrics{
+			ArchiveDuration: r.histogram("archive_duration_seconds", "Time taken to archive an event."),
+			PublishDuration: r.histogram("publish_duration_seconds", "Time taken to publish an event.", "topic"),
+		},
+		Stats: simple.StatsGauges{
+			ArchivedEvents:  r.gauge("archived_events", "Number of events in the archive."),
+			ArchiveBytes:    r.gauge("archive_bytes", "Space used by the archive."),
+			EnrolledDevices: r.gauge("enrolled_devices", "Number of enrolled devices."),
+			DeadLetters:     r.gauge("dead_letters", "Number of events waiting to be re-driven."),
+		},
+		Publish: simple.PublishMetrics{
+			Failures:     r.counter("publish_failures_total", "Number of failed publish attempts.", "topic"),
+			DeadLettered: r.counter("dead_lettered_total", "Number of events which could not be published.", "topic"),
+		},
+		Throttled:         r.counter("throttled_total", "Number of rate limited check-ins.", "limit"),
+		Duplicates:        r.counter("duplicates_total", "Number of duplicate check-ins.", "message_type"),
+		TopicRejected:     r.counter("topic_rejected_total", "Number of check-ins with an unknown push topic.", "message_type"),
+		PolicyDenied:      r.counter("policy_denied_total", "Number of devices denied by the enrollment policy.", "rule"),
+		ChallengeRejected: r.counter("challenge_rejected_total", "Number of enrollments with an invalid challenge.", "reason"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// Handler returns an http.Handler which serves the metrics gathered by g,
+// usually mounted at /metrics.
+func Handler(g stdprometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
+
+// registerer creates and registers metrics, keeping the first error.
+type registerer struct {
+	namespace string
+	reg       stdprometheus.Registerer
+	err       error
+}
+
+func (r *registerer) register(c stdprometheus.Collector) {
+	if err := r.reg.Register(c); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *registerer) counter(name, help string, labels ...string) metrics.Counter {
+	cv := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Namespace: r.namespace,
+		Subsystem: "checkin",
+		Name:      name,
+		Help:      help,
+	}, labels)
+	r.register(cv)
+	return kitprometheus.NewCounter(cv)
+}
+
+func (r *registerer) gauge(name, help string, labels ...string) metrics.Gauge {
+	gv := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{
+		Namespace: r.namespace,
+		Subsystem: "checkin",
+		Name:      name,
+		Help:      help,
+	}, labels)
+	r.register(gv)
+	return kitprometheus.NewGauge(gv)
+}
+
+func (r *registerer) histogram(name, help string, labels ...string) metrics.Histogram {
+	hv := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: r.namespace,
+		Subsystem: "checkin",
+		Name:      name,
+		Help:      help,
+	}, labels)
+	r.register(hv)
+	return kitprometheus.NewHistogram(hv)
+}
@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := stdprometheus.NewRegistry()
+	m, err := NewMetrics("mdm", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &mock.CheckinService{
+		TokenUpdateFunc: mock.SucceedCheckin,
+		CheckoutFunc:    mock.FailCheckin,
+	}
+	svc := checkin.InstrumentingMiddleware(m.Requests, m.Duration)(next)
+	svc.TokenUpdate(context.Background(), mdm.CheckinCommand{MessageType: "TokenUpdate"})
+	svc.CheckOut(context.Background(), mdm.CheckinCommand{MessageType: "CheckOut"})
+	m.Stats.EnrolledDevices.Set(3)
+
+	srv := httptest.NewServer(Handler(reg))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`mdm_checkin_requests_total{error_class="none",message_type="TokenUpdate"} 1`,
+		`mdm_checkin_requests_total{error_class="error",message_type="CheckOut"} 1`,
+		`mdm_checkin_request_duration_seconds_count{error_class="none",message_type="TokenUpdate"} 1`,
+		`mdm_checkin_enrolled_devices 3`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("want %s in metrics:\n%s", want, body)
+		}
+	}
+
+	if _, err := NewMetrics("mdm", reg); err == nil {
+		t.Error("want error registering metrics twice")
+	}
+}
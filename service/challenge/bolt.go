@@ -0,0 +1,138 @@
+package challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ChallengeBucket is the *bolt.DB bucket where challenges are stored.
+const ChallengeBucket = "mdm.Checkin.CHALLENGE"
+
+// BoltStore is a Store backed by BoltDB.
+type BoltStore struct {
+	db *bolt.DB
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewBoltStore creates a BoltStore, creating its bucket if needed.
+func NewBoltStore(db *bolt.DB) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(ChallengeBucket))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %s", err)
+	}
+	return &BoltStore{db: db, now: time.Now}, nil
+}
+
+func (s *BoltStore) Issue(ttl time.Duration) (*Challenge, error) {
+	value, err := newValue()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now().UTC()
+	c := &Challenge{Value: value, Issued: now, Expires: now.Add(ttl)}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *BoltStore) Lookup(value string) (*Challenge, error) {
+	var c *Challenge
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		c, err = get(tx, value)
+		return err
+	})
+	return c, err
+}
+
+func (s *BoltStore) Consume(value, udid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c, err := get(tx, value)
+		if err != nil {
+			return err
+		}
+		now := s.now().UTC()
+		if !now.Before(c.Expires) {
+			return ErrExpired
+		}
+		if c.ConsumedBy != "" {
+			if c.ConsumedBy != udid {
+				return ErrUsed
+			}
+			return nil
+		}
+		c.ConsumedBy = udid
+		c.Consumed = now
+		return put(tx, c)
+	})
+}
+
+// Prune deletes expired challenges, returning the number deleted.
+// Consumed challenges are kept until they expire, so that reuse is
+// reported as ErrUsed rather than ErrNotFound.
+func (s *BoltStore) Prune() (int, error) {
+	var n int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		now := s.now()
+		bkt := tx.Bucket([]byte(ChallengeBucket))
+		// keys are collected first, because deleting while iterating
+		// with a cursor skips keys.
+		var expired [][]byte
+		err := bkt.ForEach(func(k, v []byte) error {
+			var c Challenge
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("challenge %s: %s", k, err)
+			}
+			if !now.Before(c.Expires) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+func get(tx *bolt.Tx, value string) (*Challenge, error) {
+	if value == "" {
+		return nil, ErrNotFound
+	}
+	v := tx.Bucket([]byte(ChallengeBucket)).Get([]byte(value))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var c Challenge
+	if err := json.Unmarshal(v, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func put(tx *bolt.Tx, c *Challenge) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(ChallengeBucket)).Put([]byte(c.Value), data)
+}
@@ -0,0 +1,162 @@
+// Package challenge gates enrollment with one-time challenges.
+//
+// A challenge is issued for every enrollment profile and embedded in the
+// profile's Challenge key. Devices send it back in their Authenticate
+// message, where the middleware consumes it, so that each profile can be
+// used to enroll a single device, and only until the challenge expires.
+package challenge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrMissing is returned for Authenticate messages without a challenge.
+	ErrMissing = errors.New("challenge missing")
+
+	// ErrNotFound is returned for challenges which were never issued.
+	ErrNotFound = errors.New("challenge not found")
+
+	// ErrExpired is returned for challenges used after they expired.
+	ErrExpired = errors.New("challenge expired")
+
+	// ErrUsed is returned for challenges already used by another device.
+	ErrUsed = errors.New("challenge already used")
+)
+
+// Challenge is a one-time enrollment challenge.
+type Challenge struct {
+	Value   string
+	Issued  time.Time
+	Expires time.Time
+
+	// ConsumedBy is the UDID of the device which used the challenge.
+	// It is empty until the challenge is used.
+	ConsumedBy string
+	Consumed   time.Time
+}
+
+// Store issues and consumes challenges.
+type Store interface {
+	// Issue creates a challenge which is valid for ttl.
+	Issue(ttl time.Duration) (*Challenge, error)
+
+	// Lookup returns the challenge with the given value.
+	Lookup(value string) (*Challenge, error)
+
+	// Consume marks a challenge as used by the device with the given
+	// UDID. It fails with ErrNotFound, ErrExpired or ErrUsed. Consuming an
+	// unexpired challenge again for the same device succeeds, so that a
+	// device can retry an Authenticate which failed after the challenge was
+	// consumed.
+	Consume(value, udid string) error
+}
+
+// newValue returns a random challenge value.
+func newValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Config configures the challenge middleware.
+type Config struct {
+	Store Store
+
+	// Logger logs every rejected device. Optional.
+	Logger log.Logger
+
+	// Rejected counts rejected Authenticate messages, labeled by "reason".
+	// Optional.
+	Rejected metrics.Counter
+}
+
+// Middleware returns a checkin.Middleware which rejects Authenticate
+// messages whose challenge is missing, unknown, expired or used by another
+// device. Other errors of the store are returned as a *checkin.Error of
+// KindStorageUnavailable. TokenUpdate and CheckOut messages are passed on
+// unchanged. config.Store is required.
+//
+// Only Authenticate is gated. Enrolled devices send TokenUpdate messages
+// long after their challenge expired and was pruned, so a TokenUpdate is
+// passed on even from a device which never consumed a challenge, and a
+// client which skips Authenticate still reaches Enrolled in a
+// simple.CheckinService. Consumers which must only manage devices admitted
+// by the gate should require an Authenticate event for the UDID.
+func Middleware(config Config) (checkin.Middleware, error) {
+	if config.Store == nil {
+		return nil, errors.New("challenge: Store is required")
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Rejected == nil {
+		config.Rejected = discard.NewCounter()
+	}
+	return func(next checkin.Service) checkin.Service {
+		return &challengeService{next: next, config: config}
+	}, nil
+}
+
+type challengeService struct {
+	next   checkin.Service
+	config Config
+}
+
+func (svc *challengeService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	err := ErrMissing
+	if len(cmd.Challenge) > 0 {
+		err = svc.config.Store.Consume(string(cmd.Challenge), cmd.UDID)
+	}
+	if err != nil {
+		svc.config.Rejected.With("reason", reason(err)).Add(1)
+		svc.config.Logger.Log(
+			"msg", "enrollment rejected",
+			"udid", cmd.UDID,
+			"serial_number", cmd.SerialNumber,
+			"err", err,
+		)
+		if reason(err) == "error" {
+			// the store failed; the device should retry later.
+			return &checkin.Error{Kind: checkin.KindStorageUnavailable, Err: err}
+		}
+		return err
+	}
+	return svc.next.Authenticate(ctx, cmd)
+}
+
+func (svc *challengeService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.next.TokenUpdate(ctx, cmd)
+}
+
+func (svc *challengeService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.next.CheckOut(ctx, cmd)
+}
+
+// reason returns the metric label for a rejection.
+func reason(err error) string {
+	switch err {
+	case ErrMissing:
+		return "missing"
+	case ErrNotFound:
+		return "not_found"
+	case ErrExpired:
+		return "expired"
+	case ErrUsed:
+		return "used"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,177 @@
+package challenge
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestMiddleware(t *testing.T) {
+	store := setupStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	valid, err := store.Issue(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := store.Issue(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Consume(used.Value, "other-device"); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := store.Issue(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+
+	next := &mock.CheckinService{
+		AuthenticateFunc: func(context.Context, mdm.CheckinCommand) error { return nil },
+	}
+	mw, err := Middleware(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := mw(next)
+
+	tests := []struct {
+		name      string
+		challenge string
+		wantErr   error
+	}{
+		{name: "valid", challenge: valid.Value},
+		{name: "retry", challenge: valid.Value},
+		{name: "missing", wantErr: ErrMissing},
+		{name: "unknown", challenge: "apple", wantErr: ErrNotFound},
+		{name: "expired", challenge: expired.Value, wantErr: ErrExpired},
+		{name: "used", challenge: used.Value, wantErr: ErrUsed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := mdm.CheckinCommand{MessageType: "Authenticate", UDID: "some-device"}
+			if tt.challenge != "" {
+				cmd.Challenge = []byte(tt.challenge)
+			}
+			next.AuthenticateInvoked = false
+			if err := svc.Authenticate(context.Background(), cmd); err != tt.wantErr {
+				t.Fatalf("want error %v, have %v", tt.wantErr, err)
+			}
+			if want, have := tt.wantErr == nil, next.AuthenticateInvoked; want != have {
+				t.Errorf("want next service invoked %v, have %v", want, have)
+			}
+		})
+	}
+
+	c, err := store.Lookup(valid.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ConsumedBy != "some-device" {
+		t.Errorf("want challenge consumed by some-device, have %q", c.ConsumedBy)
+	}
+
+	if n, err := store.Prune(); err != nil || n != 1 {
+		t.Errorf("Prune = %d, %v, want 1 expired challenge", n, err)
+	}
+	if _, err := store.Lookup(expired.Value); err != ErrNotFound {
+		t.Errorf("want pruned challenge not found, have %v", err)
+	}
+}
+
+// TestMiddleware_tokenUpdate pins that only Authenticate is gated.
+func TestMiddleware_tokenUpdate(t *testing.T) {
+	next := &mock.CheckinService{TokenUpdateFunc: mock.SucceedCheckin}
+	mw, err := Middleware(Config{Store: setupStore(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := mdm.CheckinCommand{MessageType: "TokenUpdate", UDID: "never-authenticated"}
+	if err := mw(next).TokenUpdate(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if !next.TokenUpdateInvoked {
+		t.Error("TokenUpdate not passed to next service")
+	}
+}
+
+func TestMiddleware_noStore(t *testing.T) {
+	if _, err := Middleware(Config{}); err == nil {
+		t.Error("no Store: want error, have nil")
+	}
+}
+
+func TestBoltStore_Consume_expiredRetry(t *testing.T) {
+	store := setupStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	c, err := store.Issue(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Consume(c.Value, "some-device"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := store.Consume(c.Value, "some-device"); err != ErrExpired {
+		t.Errorf("retry after expiry: want ErrExpired, have %v", err)
+	}
+}
+
+func TestMiddleware_storeUnavailable(t *testing.T) {
+	next := &mock.CheckinService{
+		AuthenticateFunc: func(context.Context, mdm.CheckinCommand) error { return nil },
+	}
+	mw, err := Middleware(Config{Store: failingStore{errors.New("disk full")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := mw(next)
+	cmd := mdm.CheckinCommand{MessageType: "Authenticate", UDID: "some-device"}
+	cmd.Challenge = []byte("apple")
+	err = svc.Authenticate(context.Background(), cmd)
+	rec := httptest.NewRecorder()
+	checkin.EncodeError(context.Background(), err, rec)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status 503, have %d (%v)", rec.Code, err)
+	}
+	if next.AuthenticateInvoked {
+		t.Error("next service invoked after store failure")
+	}
+}
+
+// failingStore is a Store which always fails with err.
+type failingStore struct{ err error }
+
+func (s failingStore) Issue(time.Duration) (*Challenge, error) { return nil, s.err }
+func (s failingStore) Lookup(string) (*Challenge, error)       { return nil, s.err }
+func (s failingStore) Consume(string, string) error            { return s.err }
+
+func setupStore(t *testing.T) *BoltStore {
+	f, _ := ioutil.TempFile("", "bolt-")
+	f.Close()
+	os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		t.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	store, err := NewBoltStore(db)
+	if err != nil {
+		t.Fatalf("couldn't create store, err %s\n", err)
+	}
+	return store
+}
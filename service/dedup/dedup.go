@@ -0,0 +1,241 @@
+// Package dedup provides a checkin.Service middleware which detects
+// check-ins repeated by devices.
+//
+// Devices resend a check-in when they time out waiting for the response,
+// even if the server received the first one. Without deduplication every
+// retry is archived and published as a new event.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// Mode is what the middleware does with a duplicate check-in.
+type Mode int
+
+const (
+	// Drop acknowledges duplicates without passing them to the next
+	// service, so they are not archived or published.
+	Drop Mode = iota
+
+	// Mark passes duplicates to the next service, with the Duplicate
+	// field of their event set.
+	Mark
+)
+
+// DefaultWindow is how long a check-in is remembered if Config.Window is
+// not set.
+const DefaultWindow = time.Minute
+
+// Config configures the deduplication middleware.
+type Config struct {
+	// Window is how long a successful check-in is remembered.
+	// An identical check-in within the window is a duplicate.
+	// Defaults to DefaultWindow.
+	Window time.Duration
+
+	Mode Mode
+
+	// Duplicates counts duplicate check-ins, labeled by "message_type".
+	// Optional.
+	Duplicates metrics.Counter
+}
+
+// Middleware returns a checkin.Middleware which detects duplicate check-ins.
+//
+// Check-ins are identical if they have the same UDID, MessageType, user
+// and token fields. Only check-ins which the next service processed
+// without an error are remembered, so a device retrying after a failure is
+// never treated as a duplicate. A check-in identical to one which the next
+// service is still processing waits for it, and is a duplicate if it
+// succeeds. If ctx is done while waiting, a *checkin.Error of
+// KindStorageUnavailable is returned, so that the device retries.
+//
+// A successful CheckOut forgets the other check-ins of its device, so that
+// a device which unenrolls and enrolls again within the window is not
+// treated as a duplicate.
+func Middleware(config Config) checkin.Middleware {
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.Duplicates == nil {
+		config.Duplicates = discard.NewCounter()
+	}
+	return func(next checkin.Service) checkin.Service {
+		return &dedupService{
+			next:     next,
+			config:   config,
+			seen:     make(map[[sha256.Size]byte]time.Time),
+			inflight: make(map[[sha256.Size]byte]chan struct{}),
+			now:      time.Now,
+		}
+	}
+}
+
+type dedupService struct {
+	next   checkin.Service
+	config Config
+	now    func() time.Time
+
+	mu sync.Mutex
+	// seen holds the expiry time of every remembered check-in. The
+	// window is constant, so check-ins expire in the order they were
+	// added to the queue.
+	seen  map[[sha256.Size]byte]time.Time
+	queue []entry
+
+	// inflight holds the check-ins being processed by the next service.
+	// The channel is closed once the check-in is remembered or released.
+	inflight map[[sha256.Size]byte]chan struct{}
+}
+
+type entry struct {
+	sum     [sha256.Size]byte
+	udid    string
+	expires time.Time
+}
+
+func (svc *dedupService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.checkin(ctx, cmd, svc.next.Authenticate)
+}
+
+func (svc *dedupService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.checkin(ctx, cmd, svc.next.TokenUpdate)
+}
+
+func (svc *dedupService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.checkin(ctx, cmd, svc.next.CheckOut)
+}
+
+func (svc *dedupService) checkin(
+	ctx context.Context,
+	cmd mdm.CheckinCommand,
+	next func(context.Context, mdm.CheckinCommand) error,
+) error {
+	sum := contentHash(cmd)
+	for {
+		duplicate, wait := svc.reserve(sum)
+		if wait != nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				// the identical check-in is still being stored.
+				return &checkin.Error{Kind: checkin.KindStorageUnavailable, Err: ctx.Err()}
+			}
+		}
+		if !duplicate {
+			break
+		}
+		svc.config.Duplicates.With("message_type", cmd.MessageType).Add(1)
+		if svc.config.Mode == Drop {
+			return nil
+		}
+		ctx = checkin.WithEventFunc(ctx, func(e *checkin.Event) {
+			e.Duplicate = true
+		})
+		return next(ctx, cmd)
+	}
+	err := next(ctx, cmd)
+	svc.finish(sum, cmd, err == nil)
+	return err
+}
+
+// reserve reports whether the check-in is a duplicate of a remembered one.
+// If an identical check-in is in flight, it returns a channel which is
+// closed once that check-in finishes. Otherwise the check-in is reserved,
+// and finish must be called once it is processed.
+func (svc *dedupService) reserve(sum [sha256.Size]byte) (duplicate bool, wait <-chan struct{}) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.expire()
+	if _, ok := svc.seen[sum]; ok {
+		return true, nil
+	}
+	if done, ok := svc.inflight[sum]; ok {
+		return false, done
+	}
+	svc.inflight[sum] = make(chan struct{})
+	return false, nil
+}
+
+// finish releases a reserved check-in, and remembers it if it succeeded.
+// A successful CheckOut forgets the other check-ins of the device.
+func (svc *dedupService) finish(sum [sha256.Size]byte, cmd mdm.CheckinCommand, succeeded bool) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	close(svc.inflight[sum])
+	delete(svc.inflight, sum)
+	if !succeeded {
+		return
+	}
+	if cmd.MessageType == "CheckOut" {
+		for _, e := range svc.queue {
+			if e.udid == cmd.UDID {
+				delete(svc.seen, e.sum)
+			}
+		}
+	}
+	expires := svc.now().Add(svc.config.Window)
+	svc.seen[sum] = expires
+	svc.queue = append(svc.queue, entry{sum: sum, udid: cmd.UDID, expires: expires})
+}
+
+// expire forgets check-ins older than the window. svc.mu must be held.
+func (svc *dedupService) expire() {
+	now := svc.now()
+	var i int
+	for ; i < len(svc.queue) && !now.Before(svc.queue[i].expires); i++ {
+		// a check-in forgotten by a CheckOut may have been remembered
+		// again since, with a later expiry.
+		if e := svc.queue[i]; svc.seen[e.sum].Equal(e.expires) {
+			delete(svc.seen, e.sum)
+		}
+	}
+	if i > 0 {
+		svc.queue = append(svc.queue[:0], svc.queue[i:]...)
+	}
+}
+
+// contentHash returns a hash of the fields which identify a check-in.
+func contentHash(cmd mdm.CheckinCommand) [sha256.Size]byte {
+	h := sha256.New()
+	for _, field := range [][]byte{
+		[]byte(cmd.UDID),
+		[]byte(cmd.MessageType),
+		[]byte(cmd.Topic),
+		[]byte(cmd.UserID),
+		cmd.Token,
+		[]byte(cmd.PushMagic),
+		cmd.UnlockToken,
+	} {
+		writeField(h, field)
+	}
+	if cmd.AwaitingConfiguration {
+		writeField(h, []byte{1})
+	} else {
+		writeField(h, []byte{0})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// writeField writes a length prefixed field, so that the boundaries between
+// fields are part of the hash.
+func writeField(h hash.Hash, field []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(field)))
+	h.Write(n[:])
+	h.Write(field)
+}
@@ -0,0 +1,222 @@
+package dedup
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestMiddleware(t *testing.T) {
+	tokenUpdate := mdm.CheckinCommand{
+		MessageType: "TokenUpdate",
+		UDID:        "some-device",
+	}
+	tokenUpdate.Token = []byte("token")
+	newToken := tokenUpdate
+	newToken.Token = []byte("new-token")
+	otherDevice := tokenUpdate
+	otherDevice.UDID = "other-device"
+
+	tests := []struct {
+		name     string
+		mode     Mode
+		first    mdm.CheckinCommand
+		firstErr bool
+		second   mdm.CheckinCommand
+		wait     time.Duration
+
+		wantCalls     int
+		wantDuplicate bool
+	}{
+		{
+			name:          "drop_duplicate",
+			mode:          Drop,
+			first:         tokenUpdate,
+			second:        tokenUpdate,
+			wantCalls:     1,
+			wantDuplicate: true,
+		},
+		{
+			name:          "mark_duplicate",
+			mode:          Mark,
+			first:         tokenUpdate,
+			second:        tokenUpdate,
+			wantCalls:     2,
+			wantDuplicate: true,
+		},
+		{
+			name:      "token_changed",
+			first:     tokenUpdate,
+			second:    newToken,
+			wantCalls: 2,
+		},
+		{
+			name:      "other_device",
+			first:     tokenUpdate,
+			second:    otherDevice,
+			wantCalls: 2,
+		},
+		{
+			name:      "window_expired",
+			first:     tokenUpdate,
+			second:    tokenUpdate,
+			wait:      2 * time.Minute,
+			wantCalls: 2,
+		},
+		{
+			name:      "retry_after_failure",
+			first:     tokenUpdate,
+			firstErr:  true,
+			second:    tokenUpdate,
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				calls     int
+				duplicate bool
+				fail      = tt.firstErr
+			)
+			next := &mock.CheckinService{
+				TokenUpdateFunc: func(ctx context.Context, cmd mdm.CheckinCommand) error {
+					calls++
+					duplicate = checkin.NewEventWithContext(ctx, cmd).Duplicate
+					if fail {
+						fail = false
+						return mock.FailCheckin(ctx, cmd)
+					}
+					return nil
+				},
+			}
+			duplicates := &counter{}
+			svc := Middleware(Config{
+				Window:     time.Minute,
+				Mode:       tt.mode,
+				Duplicates: duplicates,
+			})(next).(*dedupService)
+			now := time.Now()
+			svc.now = func() time.Time { return now }
+
+			ctx := context.Background()
+			if err := svc.TokenUpdate(ctx, tt.first); (err != nil) != tt.firstErr {
+				t.Fatalf("first TokenUpdate error = %v", err)
+			}
+			now = now.Add(tt.wait)
+			if err := svc.TokenUpdate(ctx, tt.second); err != nil {
+				t.Fatalf("second TokenUpdate error = %v", err)
+			}
+
+			if want, have := tt.wantCalls, calls; want != have {
+				t.Errorf("want %d calls to next service, have %d", want, have)
+			}
+			if want, have := tt.wantDuplicate, duplicates.value > 0; want != have {
+				t.Errorf("want duplicate counted %v, have %v", want, have)
+			}
+			if tt.mode == Mark && duplicate != tt.wantDuplicate {
+				t.Errorf("want event marked duplicate %v, have %v", tt.wantDuplicate, duplicate)
+			}
+		})
+	}
+}
+
+func TestMiddleware_reenrollment(t *testing.T) {
+	var calls int
+	count := func(context.Context, mdm.CheckinCommand) error {
+		calls++
+		return nil
+	}
+	next := &mock.CheckinService{AuthenticateFunc: count, CheckoutFunc: count}
+	// the zero Config deduplicates within the DefaultWindow.
+	svc := Middleware(Config{})(next)
+
+	ctx := context.Background()
+	auth := mdm.CheckinCommand{MessageType: "Authenticate", UDID: "some-device"}
+	for _, cmd := range []mdm.CheckinCommand{auth, auth} {
+		if err := svc.Authenticate(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("want duplicate Authenticate dropped, have %d calls", calls)
+	}
+	if err := svc.CheckOut(ctx, mdm.CheckinCommand{MessageType: "CheckOut", UDID: "some-device"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Authenticate(ctx, auth); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("want Authenticate after CheckOut passed on, have %d calls", calls)
+	}
+}
+
+func TestMiddleware_concurrentRetry(t *testing.T) {
+	cmd := mdm.CheckinCommand{MessageType: "TokenUpdate", UDID: "some-device"}
+	var (
+		calls   int32
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	next := tokenUpdateFunc(func(ctx context.Context, cmd mdm.CheckinCommand) error {
+		atomic.AddInt32(&calls, 1)
+		close(entered)
+		<-release
+		return nil
+	})
+	duplicates := &counter{}
+	svc := Middleware(Config{Window: time.Minute, Duplicates: duplicates})(next)
+
+	ctx := context.Background()
+	errc := make(chan error, 2)
+	go func() { errc <- svc.TokenUpdate(ctx, cmd) }()
+	<-entered
+	go func() { errc <- svc.TokenUpdate(ctx, cmd) }()
+	// The retry must wait for the first check-in instead of passing it.
+	select {
+	case err := <-errc:
+		t.Fatalf("retry returned %v before the first check-in finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have := atomic.LoadInt32(&calls); have != 1 {
+		t.Errorf("want 1 call to next service, have %d", have)
+	}
+	if duplicates.value != 1 {
+		t.Errorf("want 1 duplicate counted, have %v", duplicates.value)
+	}
+}
+
+// tokenUpdateFunc is a checkin.Service which handles TokenUpdate with a
+// function, and accepts every other check-in.
+type tokenUpdateFunc func(ctx context.Context, cmd mdm.CheckinCommand) error
+
+func (fn tokenUpdateFunc) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return nil
+}
+
+func (fn tokenUpdateFunc) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return fn(ctx, cmd)
+}
+
+func (fn tokenUpdateFunc) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return nil
+}
+
+type counter struct {
+	value float64
+}
+
+func (c *counter) With(labelValues ...string) metrics.Counter { return c }
+func (c *counter) Add(delta float64)                          { c.value += delta }
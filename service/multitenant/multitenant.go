@@ -0,0 +1,193 @@
+// Package multitenant provides a checkin.Service which hosts several
+// organizations, each with its own APNs push certificates and service.
+//
+// A tenant is usually a simple.CheckinService with its own buckets and
+// topics:
+//
+//	router, _ := simple.NewRouter(simple.RoutingConfig{Prefix: "acme."})
+//	svc, _ := simple.NewService(db, producer,
+//		simple.WithBucketPrefix("acme."),
+//		simple.WithRouter(router),
+//	)
+//	mux.Add(multitenant.Tenant{
+//		ID:      "acme",
+//		Topics:  []string{"com.apple.mgmt.XServer.8b4034c3-8cd9-4121-9999-cd2ddbf9a5b1"},
+//		Service: svc,
+//	})
+package multitenant
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// ErrUnknownTenant is returned for check-ins which don't belong to a tenant.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// Tenant is an organization hosted by a Mux.
+type Tenant struct {
+	ID string
+
+	// Topics are the APNs push topics of the tenant's push certificates.
+	Topics []string
+
+	Service checkin.Service
+}
+
+// Mux implements checkin.Service by passing every check-in to the service of
+// its tenant. The tenant is the one carried by the context, set by
+// TenantFromPath, or else the one with the check-in's push topic. A
+// check-in for the tenant in the context must have one of the tenant's push
+// topics.
+// Tenants can be added and removed while the Mux is serving check-ins.
+type Mux struct {
+	mu      sync.RWMutex
+	tenants map[string]Tenant
+	topics  map[string]string // push topic to tenant ID
+}
+
+// NewMux creates a Mux serving tenants.
+func NewMux(tenants ...Tenant) (*Mux, error) {
+	m := &Mux{
+		tenants: make(map[string]Tenant),
+		topics:  make(map[string]string),
+	}
+	for _, t := range tenants {
+		if err := m.Add(t); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Add adds a tenant, replacing the tenant with the same ID if there is one.
+// A push topic can only belong to one tenant.
+func (m *Mux) Add(t Tenant) error {
+	if t.ID == "" {
+		return errors.New("multitenant: tenant ID is required")
+	}
+	if t.Service == nil {
+		return fmt.Errorf("multitenant: tenant %s has no service", t.ID)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, topic := range t.Topics {
+		if id, ok := m.topics[topic]; ok && id != t.ID {
+			return fmt.Errorf("multitenant: topic %s already belongs to tenant %s", topic, id)
+		}
+	}
+	m.remove(t.ID)
+	m.tenants[t.ID] = t
+	for _, topic := range t.Topics {
+		m.topics[topic] = t.ID
+	}
+	return nil
+}
+
+// Remove removes the tenant with the given ID. Its devices are rejected
+// from then on.
+func (m *Mux) Remove(id string) {
+	m.mu.Lock()
+	m.remove(id)
+	m.mu.Unlock()
+}
+
+func (m *Mux) remove(id string) {
+	old, ok := m.tenants[id]
+	if !ok {
+		return
+	}
+	for _, topic := range old.Topics {
+		delete(m.topics, topic)
+	}
+	delete(m.tenants, id)
+}
+
+// Tenants returns the IDs of all tenants, sorted.
+func (m *Mux) Tenants() []string {
+	m.mu.RLock()
+	ids := make([]string, 0, len(m.tenants))
+	for id := range m.tenants {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// tenant returns the tenant of a check-in.
+func (m *Mux) tenant(ctx context.Context, cmd mdm.CheckinCommand) (Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if id, ok := checkin.TenantFromContext(ctx); ok {
+		t, ok := m.tenants[id]
+		if !ok {
+			return Tenant{}, ErrUnknownTenant
+		}
+		// a device must check in with a push topic of the tenant, so
+		// that it can't check in with another tenant, or with a topic
+		// which belongs to no tenant.
+		if m.topics[cmd.Topic] != id {
+			return Tenant{}, ErrUnknownTenant
+		}
+		return t, nil
+	}
+	id, ok := m.topics[cmd.Topic]
+	if !ok {
+		return Tenant{}, ErrUnknownTenant
+	}
+	return m.tenants[id], nil
+}
+
+func (m *Mux) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	t, err := m.tenant(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return t.Service.Authenticate(checkin.NewTenantContext(ctx, t.ID), cmd)
+}
+
+func (m *Mux) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	t, err := m.tenant(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return t.Service.TokenUpdate(checkin.NewTenantContext(ctx, t.ID), cmd)
+}
+
+func (m *Mux) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	t, err := m.tenant(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return t.Service.CheckOut(checkin.NewTenantContext(ctx, t.ID), cmd)
+}
+
+// TenantFromPath returns a RequestFunc which takes the tenant ID from the
+// first path segment after prefix, so that every tenant can have its own
+// Check-in URL, such as /checkin/acme. Use it with
+// httptransport.ServerBefore.
+func TenantFromPath(prefix string) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return ctx
+		}
+		id := strings.TrimPrefix(r.URL.Path, prefix)
+		if i := strings.Index(id, "/"); i >= 0 {
+			id = id[:i]
+		}
+		if id == "" {
+			return ctx
+		}
+		return checkin.NewTenantContext(ctx, id)
+	}
+}
@@ -0,0 +1,99 @@
+package multitenant
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestMux(t *testing.T) {
+	var tenant string
+	newService := func() *mock.CheckinService {
+		return &mock.CheckinService{
+			TokenUpdateFunc: func(ctx context.Context, cmd mdm.CheckinCommand) error {
+				tenant, _ = checkin.TenantFromContext(ctx)
+				return nil
+			},
+		}
+	}
+	acme, globex := newService(), newService()
+	mux, err := NewMux(
+		Tenant{ID: "acme", Topics: []string{"com.apple.mgmt.acme"}, Service: acme},
+		Tenant{ID: "globex", Topics: []string{"com.apple.mgmt.globex"}, Service: globex},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		pathTenant string
+		topic      string
+		want       string
+		wantErr    error
+	}{
+		{name: "by_topic", topic: "com.apple.mgmt.globex", want: "globex"},
+		{name: "by_path", pathTenant: "acme", topic: "com.apple.mgmt.acme", want: "acme"},
+		{name: "unknown_topic", topic: "com.apple.mgmt.initech", wantErr: ErrUnknownTenant},
+		{name: "unknown_path", pathTenant: "initech", topic: "com.apple.mgmt.acme", wantErr: ErrUnknownTenant},
+		{name: "other_tenants_topic", pathTenant: "acme", topic: "com.apple.mgmt.globex", wantErr: ErrUnknownTenant},
+		{name: "path_unknown_topic", pathTenant: "acme", topic: "com.apple.mgmt.initech", wantErr: ErrUnknownTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant = ""
+			ctx := context.Background()
+			if tt.pathTenant != "" {
+				ctx = checkin.NewTenantContext(ctx, tt.pathTenant)
+			}
+			cmd := mdm.CheckinCommand{MessageType: "TokenUpdate", Topic: tt.topic}
+			if err := mux.TokenUpdate(ctx, cmd); err != tt.wantErr {
+				t.Fatalf("want error %v, have %v", tt.wantErr, err)
+			}
+			if tenant != tt.want {
+				t.Errorf("want tenant %q, have %q", tt.want, tenant)
+			}
+		})
+	}
+
+	// tenants can be reconfigured at runtime.
+	if err := mux.Add(Tenant{ID: "initech", Topics: []string{"com.apple.mgmt.acme"}, Service: acme}); err == nil {
+		t.Error("want error adding a topic which belongs to another tenant")
+	}
+	mux.Remove("globex")
+	cmd := mdm.CheckinCommand{MessageType: "TokenUpdate", Topic: "com.apple.mgmt.globex"}
+	if err := mux.TokenUpdate(context.Background(), cmd); err != ErrUnknownTenant {
+		t.Errorf("want ErrUnknownTenant for removed tenant, have %v", err)
+	}
+	if want, have := []string{"acme"}, mux.Tenants(); !reflect.DeepEqual(want, have) {
+		t.Errorf("want tenants %v, have %v", want, have)
+	}
+}
+
+func TestTenantFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/checkin/acme", "acme"},
+		{"/checkin/acme/", "acme"},
+		{"/checkin/", ""},
+		{"/mdm/acme", ""},
+	}
+	before := TenantFromPath("/checkin/")
+	for _, tt := range tests {
+		r, err := http.NewRequest("PUT", "http://localhost"+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, _ := checkin.TenantFromContext(before(context.Background(), r))
+		if have != tt.want {
+			t.Errorf("%s: want tenant %q, have %q", tt.path, tt.want, have)
+		}
+	}
+}
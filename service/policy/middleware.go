@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"errors"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// Config configures the enrollment policy middleware.
+type Config struct {
+	Policy *Policy
+
+	// Logger logs every denied device. Optional.
+	Logger log.Logger
+
+	// Denied counts denied devices, labeled by "rule". Optional.
+	Denied metrics.Counter
+}
+
+// Middleware returns a checkin.Middleware which evaluates the policy for
+// every Authenticate message. Denied devices get ErrDenied. The name of
+// the rule which admitted a device is recorded in the PolicyRule field of
+// its event. TokenUpdate and CheckOut messages are passed on unchanged.
+// config.Policy is required.
+//
+// Only Authenticate is evaluated. A TokenUpdate is passed on even from a
+// device which was denied, so a client which skips Authenticate still
+// reaches Enrolled in a simple.CheckinService. Consumers which must only
+// manage admitted devices should require an Authenticate event for the
+// UDID.
+func Middleware(config Config) (checkin.Middleware, error) {
+	if config.Policy == nil {
+		return nil, errors.New("policy: Policy is required")
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Denied == nil {
+		config.Denied = discard.NewCounter()
+	}
+	return func(next checkin.Service) checkin.Service {
+		return &policyService{next: next, config: config}
+	}, nil
+}
+
+type policyService struct {
+	next   checkin.Service
+	config Config
+}
+
+func (svc *policyService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	rule, allowed := svc.config.Policy.Evaluate(cmd)
+	if !allowed {
+		svc.config.Denied.With("rule", rule).Add(1)
+		svc.config.Logger.Log(
+			"msg", "enrollment denied",
+			"rule", rule,
+			"udid", cmd.UDID,
+			"serial_number", cmd.SerialNumber,
+			"product_name", cmd.ProductName,
+			"os_version", cmd.OSVersion,
+		)
+		return ErrDenied
+	}
+	ctx = checkin.WithEventFunc(ctx, func(e *checkin.Event) {
+		e.PolicyRule = rule
+	})
+	return svc.next.Authenticate(ctx, cmd)
+}
+
+func (svc *policyService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.next.TokenUpdate(ctx, cmd)
+}
+
+func (svc *policyService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.next.CheckOut(ctx, cmd)
+}
@@ -0,0 +1,304 @@
+// Package policy provides an enrollment admission policy for the MDM
+// Check-in protocol.
+//
+// A policy is an ordered list of rules evaluated against the Authenticate
+// message of a device. The first matching rule decides if the device may
+// enroll. Rules are loaded from a JSON file:
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"name": "stolen", "action": "deny", "serial_numbers": ["C02RX6G8G8WP"]},
+//	    {"name": "macs", "action": "allow", "product_families": ["MacBookPro", "iMac"], "min_os_version": "10.12"},
+//	    {"name": "ios", "action": "allow", "product_families": ["iPhone", "iPad"], "min_os_version": "10.0"}
+//	  ]
+//	}
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// ErrDenied is returned for devices which the policy does not admit.
+var ErrDenied = errors.New("enrollment denied by policy")
+
+// DefaultRuleName is the rule name recorded when no rule matches a device.
+const DefaultRuleName = "default"
+
+// Action is what a rule does with the devices it matches.
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Rules is an enrollment policy.
+type Rules struct {
+	// Default is the action for devices which match no rule.
+	// Defaults to Allow.
+	Default Action `json:"default"`
+
+	Rules []Rule `json:"rules"`
+}
+
+// Rule matches devices by their Authenticate message. A device matches if
+// it matches every non-empty field of the rule, and it matches a list if it
+// matches any of its entries.
+type Rule struct {
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+
+	SerialNumbers []string `json:"serial_numbers,omitempty"`
+	IMEIs         []string `json:"imeis,omitempty"`
+
+	// Models are path.Match patterns matched against the Model of the
+	// device, such as "MLL42*".
+	Models []string `json:"models,omitempty"`
+
+	// ProductFamilies match the start of the ProductName of the device,
+	// so that "iPad" matches "iPad6,3".
+	ProductFamilies []string `json:"product_families,omitempty"`
+
+	// MinOSVersion and MaxOSVersion are inclusive bounds for the
+	// OSVersion of the device.
+	MinOSVersion string `json:"min_os_version,omitempty"`
+	MaxOSVersion string `json:"max_os_version,omitempty"`
+
+	// Challenges match the enrollment profile challenge sent by the device.
+	Challenges []string `json:"challenges,omitempty"`
+}
+
+// Parse reads Rules in JSON format from r, and validates them.
+func Parse(r io.Reader) (Rules, error) {
+	var rules Rules
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return Rules{}, err
+	}
+	if rules.Default == "" {
+		rules.Default = Allow
+	}
+	if err := rules.validate(); err != nil {
+		return Rules{}, err
+	}
+	return rules, nil
+}
+
+func (rules Rules) validate() error {
+	if rules.Default != Allow && rules.Default != Deny {
+		return fmt.Errorf("invalid default action %q", rules.Default)
+	}
+	names := make(map[string]bool)
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if rule.Name == DefaultRuleName || names[rule.Name] {
+			return fmt.Errorf("rule %d: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Action != Allow && rule.Action != Deny {
+			return fmt.Errorf("rule %s: invalid action %q", rule.Name, rule.Action)
+		}
+		for _, pattern := range rule.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %s: model pattern %q: %s", rule.Name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate returns the name of the first rule matching cmd and whether the
+// device is admitted. DefaultRuleName is returned if no rule matches.
+func (rules Rules) Evaluate(cmd mdm.CheckinCommand) (string, bool) {
+	for _, rule := range rules.Rules {
+		if rule.match(cmd) {
+			return rule.Name, rule.Action == Allow
+		}
+	}
+	return DefaultRuleName, rules.Default == Allow
+}
+
+func (r Rule) match(cmd mdm.CheckinCommand) bool {
+	if len(r.SerialNumbers) > 0 && !contains(r.SerialNumbers, cmd.SerialNumber) {
+		return false
+	}
+	if len(r.IMEIs) > 0 && !contains(r.IMEIs, cmd.IMEI) {
+		return false
+	}
+	if len(r.Challenges) > 0 && !contains(r.Challenges, string(cmd.Challenge)) {
+		return false
+	}
+	if len(r.Models) > 0 && !matchAny(r.Models, cmd.Model) {
+		return false
+	}
+	if len(r.ProductFamilies) > 0 && !hasAnyPrefix(cmd.ProductName, r.ProductFamilies) {
+		return false
+	}
+	if r.MinOSVersion != "" && compareVersions(cmd.OSVersion, r.MinOSVersion) < 0 {
+		return false
+	}
+	if r.MaxOSVersion != "" && compareVersions(cmd.OSVersion, r.MaxOSVersion) > 0 {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares dotted version strings such as "10.12.1",
+// returning -1, 0 or 1. Missing components are zero, so "10.12" equals
+// "10.12.0". Components which are not numbers are compared as strings.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil:
+			if xn != yn {
+				if xn < yn {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Policy holds the current Rules. The rules can be replaced while the
+// policy is in use.
+type Policy struct {
+	mu    sync.RWMutex
+	rules Rules
+}
+
+// New returns a Policy with rules.
+func New(rules Rules) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Rules returns the current rules.
+func (p *Policy) Rules() Rules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.rules
+}
+
+// SetRules replaces the current rules.
+func (p *Policy) SetRules(rules Rules) {
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+}
+
+// Evaluate evaluates cmd with the current rules.
+func (p *Policy) Evaluate(cmd mdm.CheckinCommand) (string, bool) {
+	return p.Rules().Evaluate(cmd)
+}
+
+// LoadFile parses the rules in the file at path.
+func LoadFile(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Rules{}, err
+	}
+	defer f.Close()
+	rules, err := Parse(f)
+	if err != nil {
+		return Rules{}, fmt.Errorf("%s: %s", path, err)
+	}
+	return rules, nil
+}
+
+// WatchFile starts a goroutine which reloads the rules of p from the file
+// at path whenever its modification time changes, checking every interval,
+// until ctx is done. If the file can't be loaded, the error is logged and p
+// keeps its current rules.
+func WatchFile(ctx context.Context, p *Policy, path string, interval time.Duration, logger log.Logger) {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+	go watchFile(ctx, p, path, modTime, interval, logger)
+}
+
+func watchFile(ctx context.Context, p *Policy, path string, modTime time.Time, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			logger.Log("msg", "stat policy file", "path", path, "err", err)
+			continue
+		}
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+		rules, err := LoadFile(path)
+		if err != nil {
+			logger.Log("msg", "reload policy file", "path", path, "err", err)
+			continue
+		}
+		p.SetRules(rules)
+		logger.Log("msg", "reloaded policy file", "path", path, "rules", len(rules.Rules))
+	}
+}
@@ -0,0 +1,179 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+const testRules = `{
+  "default": "deny",
+  "rules": [
+    {"name": "stolen", "action": "deny", "serial_numbers": ["C02RX6G8G8WP"]},
+    {"name": "old-macs", "action": "deny", "product_families": ["MacBookPro", "iMac"], "max_os_version": "10.11.6"},
+    {"name": "macs", "action": "allow", "product_families": ["MacBookPro", "iMac"]},
+    {"name": "ios", "action": "allow", "product_families": ["iPhone", "iPad"], "min_os_version": "10"},
+    {"name": "challenge", "action": "allow", "challenges": ["apple"]}
+  ]
+}`
+
+func authenticate(serial, product, osVersion, challenge string) mdm.CheckinCommand {
+	cmd := mdm.CheckinCommand{MessageType: "Authenticate", UDID: "some-device"}
+	cmd.SerialNumber = serial
+	cmd.ProductName = product
+	cmd.OSVersion = osVersion
+	if challenge != "" {
+		cmd.Challenge = []byte(challenge)
+	}
+	return cmd
+}
+
+func TestEvaluate(t *testing.T) {
+	rules, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		cmd       mdm.CheckinCommand
+		wantRule  string
+		wantAllow bool
+	}{
+		{"denylist", authenticate("C02RX6G8G8WP", "MacBookPro11,5", "10.12.1", ""), "stolen", false},
+		{"max_os_version", authenticate("C02AAAAAAAAA", "MacBookPro11,5", "10.11.6", ""), "old-macs", false},
+		{"product_family", authenticate("C02AAAAAAAAA", "iMac17,1", "10.12.1", ""), "macs", true},
+		{"min_os_version", authenticate("DAAAAAAAAAAA", "iPhone9,1", "10.1.1", ""), "ios", true},
+		{"below_min_os_version", authenticate("DAAAAAAAAAAA", "iPad5,3", "9.3.5", ""), DefaultRuleName, false},
+		{"challenge", authenticate("DAAAAAAAAAAA", "iPad5,3", "9.3.5", "apple"), "challenge", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, allow := rules.Evaluate(tt.cmd)
+			if rule != tt.wantRule || allow != tt.wantAllow {
+				t.Errorf("want %s %v, have %s %v", tt.wantRule, tt.wantAllow, rule, allow)
+			}
+		})
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, data := range []string{
+		`{"default": "maybe"}`,
+		`{"rules": [{"action": "allow"}]}`,
+		`{"rules": [{"name": "a", "action": "allow"}, {"name": "a", "action": "deny"}]}`,
+		`{"rules": [{"name": "a", "action": "allow", "models": ["["]}]}`,
+	} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("want error parsing %s", data)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"10.12.1", "10.12.1", 0},
+		{"10.12", "10.12.0", 0},
+		{"10.9", "10.12", -1},
+		{"10.12.1", "10.12", 1},
+		{"9.3.5", "10", -1},
+	}
+	for _, tt := range tests {
+		if have := compareVersions(tt.a, tt.b); have != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, have, tt.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rules, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded string
+	next := &mock.CheckinService{
+		AuthenticateFunc: func(ctx context.Context, cmd mdm.CheckinCommand) error {
+			recorded = checkin.NewEventWithContext(ctx, cmd).PolicyRule
+			return nil
+		},
+	}
+	mw, err := Middleware(Config{Policy: New(rules)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := mw(next)
+	ctx := context.Background()
+
+	if err := svc.Authenticate(ctx, authenticate("C02AAAAAAAAA", "iMac17,1", "10.12.1", "")); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != "macs" {
+		t.Errorf("want rule %q recorded in event, have %q", "macs", recorded)
+	}
+
+	next.AuthenticateInvoked = false
+	if err := svc.Authenticate(ctx, authenticate("C02RX6G8G8WP", "iMac17,1", "10.12.1", "")); err != ErrDenied {
+		t.Errorf("want ErrDenied, have %v", err)
+	}
+	if next.AuthenticateInvoked {
+		t.Error("denied device passed to next service")
+	}
+	// only Authenticate is evaluated.
+	next.TokenUpdateFunc = mock.SucceedCheckin
+	denied := authenticate("C02RX6G8G8WP", "iMac17,1", "10.12.1", "")
+	denied.MessageType = "TokenUpdate"
+	if err := svc.TokenUpdate(ctx, denied); err != nil || !next.TokenUpdateInvoked {
+		t.Errorf("want TokenUpdate of denied device passed on, have %v", err)
+	}
+
+	if _, err := Middleware(Config{}); err == nil {
+		t.Error("no Policy: want error, have nil")
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "policy-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"default": "allow"}`)
+	f.Close()
+
+	rules, err := LoadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(rules)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	WatchFile(ctx, p, f.Name(), 10*time.Millisecond, log.NewNopLogger())
+
+	// make sure the modification time changes on file systems with a
+	// coarse timestamp resolution.
+	later := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"default": "deny"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f.Name(), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for p.Rules().Default != Deny {
+		if time.Now().After(deadline) {
+			t.Fatal("policy file not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
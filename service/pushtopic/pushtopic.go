@@ -0,0 +1,209 @@
+// Package pushtopic provides a checkin.Service middleware which rejects
+// devices enrolling with a push topic the server holds no APNs push
+// certificate for. Such devices can never be woken up with a push
+// notification.
+package pushtopic
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/net/context"
+)
+
+// ErrUnknownTopic is returned for check-ins with a push topic which is not
+// allowed.
+var ErrUnknownTopic = errors.New("push topic does not match a configured push certificate")
+
+// oidUserID is the subject attribute which holds the topic of an APNs
+// push certificate.
+var oidUserID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+// Set is a set of allowed push topics. It is safe for concurrent use, so
+// topics can be added while the middleware is serving check-ins.
+type Set struct {
+	mu     sync.RWMutex
+	topics map[string]bool
+}
+
+// NewSet returns a Set holding topics.
+func NewSet(topics ...string) *Set {
+	s := &Set{topics: make(map[string]bool)}
+	s.Add(topics...)
+	return s
+}
+
+// Add adds topics to the set.
+func (s *Set) Add(topics ...string) {
+	s.mu.Lock()
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+	s.mu.Unlock()
+}
+
+// Contains reports whether topic is in the set.
+func (s *Set) Contains(topic string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.topics[topic]
+}
+
+// Topics returns the topics in the set, sorted.
+func (s *Set) Topics() []string {
+	s.mu.RLock()
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	s.mu.RUnlock()
+	sort.Strings(topics)
+	return topics
+}
+
+// TopicFromCertificate returns the push topic of an APNs push certificate,
+// which is the UID attribute of its subject.
+func TopicFromCertificate(cert *x509.Certificate) (string, error) {
+	for _, name := range cert.Subject.Names {
+		if !name.Type.Equal(oidUserID) {
+			continue
+		}
+		if topic, ok := name.Value.(string); ok && topic != "" {
+			return topic, nil
+		}
+	}
+	return "", fmt.Errorf("certificate %q has no push topic", cert.Subject.CommonName)
+}
+
+// LoadPEM returns the push topics of the certificates in a PEM file.
+// Blocks other than certificates, such as private keys, are skipped.
+func LoadPEM(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var topics []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		topic, err := TopicFromCertificate(cert)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		topics = append(topics, topic)
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return topics, nil
+}
+
+// LoadPKCS12 returns the push topic of the certificate in a PKCS#12 file,
+// such as one exported from Keychain Access.
+func LoadPKCS12(path, password string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	_, cert, err := pkcs12.Decode(data, password)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+	return TopicFromCertificate(cert)
+}
+
+// Config configures the push topic validation middleware.
+type Config struct {
+	Allowed *Set
+
+	// Logger logs every rejected check-in. Optional.
+	Logger log.Logger
+
+	// Rejected counts rejected check-ins, labeled by "message_type".
+	// Optional.
+	Rejected metrics.Counter
+}
+
+// Middleware returns a checkin.Middleware which rejects Authenticate and
+// TokenUpdate messages with a push topic that is not allowed, returning
+// ErrUnknownTopic. CheckOut messages are always passed on, so that devices
+// which were enrolled before a certificate was removed can unenroll.
+// config.Allowed is required.
+func Middleware(config Config) (checkin.Middleware, error) {
+	if config.Allowed == nil {
+		return nil, errors.New("pushtopic: Allowed set is required")
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Rejected == nil {
+		config.Rejected = discard.NewCounter()
+	}
+	return func(next checkin.Service) checkin.Service {
+		return &validatingService{next: next, config: config}
+	}, nil
+}
+
+type validatingService struct {
+	next   checkin.Service
+	config Config
+}
+
+func (svc *validatingService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	if err := svc.validate(cmd); err != nil {
+		return err
+	}
+	return svc.next.Authenticate(ctx, cmd)
+}
+
+func (svc *validatingService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	if err := svc.validate(cmd); err != nil {
+		return err
+	}
+	return svc.next.TokenUpdate(ctx, cmd)
+}
+
+func (svc *validatingService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return svc.next.CheckOut(ctx, cmd)
+}
+
+func (svc *validatingService) validate(cmd mdm.CheckinCommand) error {
+	if svc.config.Allowed.Contains(cmd.Topic) {
+		return nil
+	}
+	svc.config.Rejected.With("message_type", cmd.MessageType).Add(1)
+	reason := "push topic does not match any configured push certificate"
+	if cmd.Topic == "" {
+		reason = "missing push topic"
+	}
+	svc.config.Logger.Log(
+		"msg", "rejected check-in",
+		"reason", reason,
+		"message_type", cmd.MessageType,
+		"udid", cmd.UDID,
+		"topic", cmd.Topic,
+	)
+	return ErrUnknownTopic
+}
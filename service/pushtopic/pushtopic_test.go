@@ -0,0 +1,107 @@
+package pushtopic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+const testTopic = "com.apple.mgmt.XServer.8b4034c3-8cd9-4121-9999-cd2ddbf9a5b1"
+
+func TestLoadPEM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "APSP:8b4034c3-8cd9-4121-9999-cd2ddbf9a5b1",
+			ExtraNames: []pkix.AttributeTypeAndValue{
+				{Type: oidUserID, Value: testTopic},
+			},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "push-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	f.Close()
+
+	topics, err := LoadPEM(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{testTopic}; !reflect.DeepEqual(want, topics) {
+		t.Errorf("want topics %v, have %v", want, topics)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	next := &mock.CheckinService{
+		AuthenticateFunc: func(context.Context, mdm.CheckinCommand) error { return nil },
+		TokenUpdateFunc:  func(context.Context, mdm.CheckinCommand) error { return nil },
+		CheckoutFunc:     func(context.Context, mdm.CheckinCommand) error { return nil },
+	}
+	var logs bytes.Buffer
+	mw, err := Middleware(Config{
+		Allowed: NewSet(testTopic),
+		Logger:  log.NewLogfmtLogger(&logs),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := mw(next)
+	ctx := context.Background()
+
+	allowed := mdm.CheckinCommand{MessageType: "Authenticate", Topic: testTopic}
+	if err := svc.Authenticate(ctx, allowed); err != nil {
+		t.Errorf("Authenticate with allowed topic: %v", err)
+	}
+
+	unknown := mdm.CheckinCommand{MessageType: "TokenUpdate", Topic: "com.apple.mgmt.other", UDID: "some-device"}
+	next.TokenUpdateInvoked = false
+	if err := svc.TokenUpdate(ctx, unknown); err != ErrUnknownTopic {
+		t.Errorf("want ErrUnknownTopic, have %v", err)
+	}
+	if next.TokenUpdateInvoked {
+		t.Error("rejected TokenUpdate passed to next service")
+	}
+	if !strings.Contains(logs.String(), "udid=some-device") {
+		t.Errorf("rejection not logged: %q", logs.String())
+	}
+
+	unknown.MessageType = "CheckOut"
+	if err := svc.CheckOut(ctx, unknown); err != nil {
+		t.Errorf("CheckOut with unknown topic: %v", err)
+	}
+
+	if _, err := Middleware(Config{}); err == nil {
+		t.Error("no Allowed set: want error, have nil")
+	}
+}
@@ -0,0 +1,227 @@
+package simple
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/micromdm/checkin"
+)
+
+// ErrStopIteration can be returned by the function passed to ForEachEvent to
+// stop iterating without causing ForEachEvent to return an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// EventFilter selects events from the Check-in archive.
+// The zero value matches every event.
+type EventFilter struct {
+	// Since and Until limit the archive time of events. Since is inclusive,
+	// Until is exclusive. A zero time leaves that end of the range open.
+	Since time.Time
+	Until time.Time
+
+	UDID        string
+	MessageType string
+
+	// Bucket is the archive bucket to read. Defaults to CheckinBucket.
+	Bucket string
+}
+
+func (f EventFilter) match(e *checkin.Event) bool {
+	if f.UDID != "" && e.Command.UDID != f.UDID {
+		return false
+	}
+	if f.MessageType != "" && e.Command.MessageType != f.MessageType {
+		return false
+	}
+	return true
+}
+
+// ForEachEvent calls fn for every archived event matching the filter, oldest
+// first. Iteration stops at the first error returned by fn, which is
+// returned by ForEachEvent unless it is ErrStopIteration.
+// Only a read transaction is used, so the db may be opened read-only.
+func ForEachEvent(db *bolt.DB, filter EventFilter, fn func(*checkin.Event) error) error {
+	return forEachEvent(db, filter, nil, func(k []byte, e *checkin.Event) error {
+		return fn(e)
+	})
+}
+
+// EventPage is a page of archived events.
+type EventPage struct {
+	Events []*checkin.Event
+
+	// Next is the cursor of the next page, or empty if there are no more
+	// events.
+	Next string
+}
+
+// ErrInvalidCursor is returned for a cursor which was not returned in the
+// Next field of an EventPage.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListEvents returns up to limit archived events matching the filter, oldest
+// first. The cursor is empty for the first page, and the Next cursor of the
+// previous page otherwise.
+func ListEvents(db *bolt.DB, filter EventFilter, cursor string, limit int) (*EventPage, error) {
+	var after []byte
+	if cursor != "" {
+		if !isArchiveKey(cursor) {
+			return nil, ErrInvalidCursor
+		}
+		after = []byte(cursor)
+	}
+	page := new(EventPage)
+	var last string
+	err := forEachEvent(db, filter, after, func(k []byte, e *checkin.Event) error {
+		if len(page.Events) == limit {
+			// there is at least one more event.
+			page.Next = last
+			return ErrStopIteration
+		}
+		page.Events = append(page.Events, e)
+		last = string(k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// forEachEvent calls fn with the key of every archived event matching the
+// filter, starting after the key after if it is not nil.
+//
+// If the filter has a UDID and the archive has an index, only the events of
+// that device are read. Otherwise every event in the time range is read,
+// which takes time proportional to the size of the archive.
+func forEachEvent(db *bolt.DB, filter EventFilter, after []byte, fn func([]byte, *checkin.Event) error) error {
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := filter.Bucket
+		if bucket == "" {
+			bucket = CheckinBucket
+		}
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", bucket)
+		}
+		// keys iterates over the archive keys to read: those of the
+		// device if the archive is indexed, and all of them otherwise.
+		keys := bkt
+		if index := tx.Bucket([]byte(indexBucket(bucket))); index != nil && filter.UDID != "" {
+			if keys = index.Bucket([]byte(filter.UDID)); keys == nil {
+				// the device has no archived events.
+				return nil
+			}
+		}
+		var until []byte
+		if !filter.Until.IsZero() {
+			until = archiveKey(filter.Until.UnixNano())
+		}
+		c := keys.Cursor()
+		k, _ := c.First()
+		if !filter.Since.IsZero() {
+			k, _ = c.Seek(archiveKey(filter.Since.UnixNano()))
+		}
+		if after != nil && k != nil && string(k) <= string(after) {
+			if k, _ = c.Seek(after); k != nil && string(k) == string(after) {
+				k, _ = c.Next()
+			}
+		}
+		for ; k != nil; k, _ = c.Next() {
+			if until != nil && string(k) >= string(until) {
+				return nil
+			}
+			v := bkt.Get(k)
+			if v == nil {
+				return fmt.Errorf("indexed event %s not found", k)
+			}
+			var event checkin.Event
+			if err := checkin.UnmarshalEvent(v, &event); err != nil {
+				return fmt.Errorf("unmarshal event %s: %s", k, err)
+			}
+			if !filter.match(&event) {
+				continue
+			}
+			if err := fn(k, &event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrStopIteration {
+		return nil
+	}
+	return err
+}
+
+// indexBucket returns the name of the bucket indexing the named archive
+// bucket. It holds a bucket for every UDID, with the archive keys of the
+// device's events.
+func indexBucket(archive string) string {
+	return archive + ".UDID"
+}
+
+// indexEvent adds an archived event to the index of the archive.
+// Events without a UDID are not indexed.
+func indexEvent(tx *bolt.Tx, archive string, key []byte, event *checkin.Event) error {
+	if event.Command.UDID == "" {
+		return nil
+	}
+	index := tx.Bucket([]byte(indexBucket(archive)))
+	if index == nil {
+		return fmt.Errorf("bucket %q not found!", indexBucket(archive))
+	}
+	bkt, err := index.CreateBucketIfNotExists([]byte(event.Command.UDID))
+	if err != nil {
+		return err
+	}
+	return bkt.Put(key, []byte{})
+}
+
+// createIndex creates the index of an archive bucket, indexing the events
+// archived before the archive had an index.
+func createIndex(tx *bolt.Tx, archive string) error {
+	if tx.Bucket([]byte(indexBucket(archive))) != nil {
+		return nil
+	}
+	if _, err := tx.CreateBucket([]byte(indexBucket(archive))); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(archive)).ForEach(func(k, v []byte) error {
+		var event checkin.Event
+		if err := checkin.UnmarshalEvent(v, &event); err != nil {
+			return fmt.Errorf("unmarshal event %s: %s", k, err)
+		}
+		return indexEvent(tx, archive, k, &event)
+	})
+}
+
+// EventKey returns the archive key of an archived event, which is also a
+// cursor for ListEvents: the page after it starts with the next event.
+// Keys are unique within an archive bucket and sort in archive order.
+func EventKey(e *checkin.Event) string {
+	return string(archiveKey(e.Time.UnixNano()))
+}
+
+// archiveKey returns the bucket key for an event archived at nano.
+// Keys are decimal timestamps, which sort chronologically as long as they
+// have the same number of digits.
+func archiveKey(nano int64) []byte {
+	return []byte(fmt.Sprintf("%d", nano))
+}
+
+// isArchiveKey reports whether s is an archive key: a timestamp of 19
+// decimal digits, which is every timestamp from 2001 until 2286.
+func isArchiveKey(s string) bool {
+	if len(s) != 19 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,283 @@
+package simple
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+)
+
+func TestForEachEvent(t *testing.T) {
+	svc := setupDB(t)
+	names := []string{"Authenticate", "TokenUpdate", "CheckOut", "TokenUpdate"}
+	base := time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range names {
+		event := checkin.NewEvent(mustLoadCommand(t, name))
+		event.Time = base.Add(time.Duration(i) * time.Second)
+		if _, err := svc.archive(event.Time.UnixNano(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   []string
+	}{
+		{
+			name: "all",
+			want: names,
+		},
+		{
+			name:   "message_type",
+			filter: EventFilter{MessageType: "TokenUpdate"},
+			want:   []string{"TokenUpdate", "TokenUpdate"},
+		},
+		{
+			name: "time_range",
+			filter: EventFilter{
+				Since: base.Add(time.Second),
+				Until: base.Add(3 * time.Second),
+			},
+			want: []string{"TokenUpdate", "CheckOut"},
+		},
+		{
+			name:   "unknown_udid",
+			filter: EventFilter{UDID: "unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var have []string
+			err := ForEachEvent(svc.db, tt.filter, func(e *checkin.Event) error {
+				have = append(have, e.Command.MessageType)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != len(tt.want) {
+				t.Fatalf("want %v, have %v", tt.want, have)
+			}
+			for i := range have {
+				if have[i] != tt.want[i] {
+					t.Errorf("want %v, have %v", tt.want, have)
+				}
+			}
+		})
+	}
+
+	t.Run("stop_iteration", func(t *testing.T) {
+		var n int
+		err := ForEachEvent(svc.db, EventFilter{}, func(e *checkin.Event) error {
+			n++
+			return ErrStopIteration
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("want 1 event, have %d", n)
+		}
+	})
+}
+
+func TestForEachEvent_index(t *testing.T) {
+	svc := setupDB(t)
+	cmd := mustLoadCommand(t, "TokenUpdate")
+	other := cmd
+	other.UDID = "other-device"
+	for i, cmd := range []mdm.CheckinCommand{cmd, other, cmd} {
+		if _, err := svc.archive(int64(i+1), checkin.NewEvent(cmd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(udid string) int {
+		var n int
+		err := ForEachEvent(svc.db, EventFilter{UDID: udid}, func(e *checkin.Event) error {
+			if e.Command.UDID != udid {
+				t.Errorf("want UDID %q, have %q", udid, e.Command.UDID)
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if want, have := 2, count(cmd.UDID); want != have {
+		t.Errorf("want %d events, have %d", want, have)
+	}
+
+	// an archive without an index is indexed by NewService.
+	err := svc.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(indexBucket(CheckinBucket)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, count(other.UDID); want != have {
+		t.Errorf("unindexed archive: want %d events, have %d", want, have)
+	}
+	if _, err := NewService(svc.db, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, count(other.UDID); want != have {
+		t.Errorf("reindexed archive: want %d events, have %d", want, have)
+	}
+}
+
+func TestBatchArchive(t *testing.T) {
+	svc := setupDB(t)
+	WithBatchArchive(10, 5*time.Millisecond)(svc)
+
+	const n = 50
+	cmd := mustLoadCommand(t, "TokenUpdate")
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		// every event has the same timestamp, to check that concurrent
+		// events don't overwrite each other.
+		go func() {
+			_, err := svc.archiveFn(1111, checkin.NewEvent(cmd))
+			errs <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archived int
+	seen := make(map[uint64]bool)
+	keys := make(map[string]bool)
+	err := ForEachEvent(svc.db, EventFilter{}, func(e *checkin.Event) error {
+		archived++
+		if e.Sequence < 1 || e.Sequence > n || seen[e.Sequence] {
+			t.Errorf("unexpected sequence number %d", e.Sequence)
+		}
+		seen[e.Sequence] = true
+		if key := EventKey(e); keys[key] {
+			t.Errorf("duplicate event key %s", key)
+		}
+		keys[EventKey(e)] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived != n {
+		t.Errorf("want %d archived events, have %d", n, archived)
+	}
+	if svc.db.MaxBatchSize != bolt.DefaultMaxBatchSize || svc.db.MaxBatchDelay != bolt.DefaultMaxBatchDelay {
+		t.Error("WithBatchArchive changed the batch settings of the db")
+	}
+}
+
+func TestWithBucket(t *testing.T) {
+	svc := setupDB(t, WithBucket("acme.mdm.Checkin.ARCHIVE"), WithPublisher(&keyedPublisher{}))
+	if err := svc.CheckOut(context.Background(), mustLoadCommand(t, "CheckOut")); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err := ForEachEvent(svc.db, EventFilter{Bucket: "acme.mdm.Checkin.ARCHIVE"}, func(*checkin.Event) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 event in tenant bucket, have %d", n)
+	}
+}
+
+func TestWithBucketPrefix(t *testing.T) {
+	acme := setupDB(t, WithBucketPrefix("acme."), WithPublisher(&flakyPublisher{failures: 1}),
+		WithRetry(RetryPolicy{MaxAttempts: 1}, PublishMetrics{}))
+	globex, err := NewService(acme.db, nil, WithBucketPrefix("globex."), WithPublisher(&keyedPublisher{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := acme.TokenUpdate(ctx, mustLoadCommand(t, "TokenUpdate")); err != nil {
+		t.Fatal(err)
+	}
+
+	var devices int
+	if err := globex.ForEachDevice(func(*Device) error { devices++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if devices != 0 {
+		t.Errorf("want no devices of other tenant, have %d", devices)
+	}
+	letters, err := globex.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 0 {
+		t.Errorf("want no dead letters of other tenant, have %d", len(letters))
+	}
+	if letters, err = acme.DeadLetters(); err != nil || len(letters) != 1 {
+		t.Errorf("want 1 dead letter, have %d (err %v)", len(letters), err)
+	}
+
+	// the sequence of a device is kept per tenant.
+	if err := globex.TokenUpdate(ctx, mustLoadCommand(t, "TokenUpdate")); err != nil {
+		t.Fatal(err)
+	}
+	page, err := globex.ListEvents(EventFilter{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].Sequence != 1 {
+		t.Errorf("want 1 event with sequence 1, have %+v", page.Events)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	svc := setupDB(t)
+	base := time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		event := checkin.NewEvent(mustLoadCommand(t, "TokenUpdate"))
+		event.Time = base.Add(time.Duration(i) * time.Second)
+		if _, err := svc.archive(event.Time.UnixNano(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sizes []int
+	var cursor string
+	for {
+		page, err := svc.ListEvents(EventFilter{}, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(page.Events))
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("want pages of 2, 2 and 1 events, have %v", sizes)
+	}
+
+	page, err := svc.ListEvents(EventFilter{Since: base.Add(3 * time.Second)}, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 2 || page.Next != "" {
+		t.Errorf("want last 2 events and no next page, have %d events, next %q", len(page.Events), page.Next)
+	}
+
+	for _, cursor := range []string{"bogus", "-1", "+123456789012345678", "12345"} {
+		if _, err := svc.ListEvents(EventFilter{}, cursor, 2); err != ErrInvalidCursor {
+			t.Errorf("cursor %q: want ErrInvalidCursor, have %v", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package simple
+
+import (
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// batcher groups functions called concurrently into shared write
+// transactions, like bolt.DB.Batch. It has its own size and delay, so that
+// the settings of a *bolt.DB shared with other users are left alone.
+type batcher struct {
+	db       *bolt.DB
+	maxSize  int
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	pending *batch
+}
+
+type batch struct {
+	b     *batcher
+	timer *time.Timer
+	once  sync.Once
+	calls []batchCall
+}
+
+type batchCall struct {
+	fn  func(*bolt.Tx) error
+	err chan error
+}
+
+// update calls fn in a write transaction shared with concurrent callers.
+// It returns once the transaction has been committed or rolled back. As with
+// bolt.DB.Batch, fn may be called more than once, and must have no effects
+// outside of the transaction.
+func (b *batcher) update(fn func(*bolt.Tx) error) error {
+	errc := make(chan error, 1)
+	b.mu.Lock()
+	if b.pending == nil {
+		p := &batch{b: b}
+		p.timer = time.AfterFunc(b.maxDelay, p.trigger)
+		b.pending = p
+	}
+	p := b.pending
+	p.calls = append(p.calls, batchCall{fn: fn, err: errc})
+	full := len(p.calls) >= b.maxSize
+	b.mu.Unlock()
+	if full {
+		p.trigger()
+	}
+	return <-errc
+}
+
+// trigger runs the batch, once.
+func (p *batch) trigger() {
+	p.once.Do(p.run)
+}
+
+func (p *batch) run() {
+	p.b.mu.Lock()
+	p.timer.Stop()
+	if p.b.pending == p {
+		p.b.pending = nil
+	}
+	p.b.mu.Unlock()
+
+	err := p.b.db.Update(func(tx *bolt.Tx) error {
+		for _, call := range p.calls {
+			if err := call.fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for _, call := range p.calls {
+			call.err <- nil
+		}
+		return
+	}
+	// the transaction was rolled back. Retry every call in a transaction
+	// of its own, so that one failing call doesn't fail the others.
+	for _, call := range p.calls {
+		call.err <- p.b.db.Update(call.fn)
+	}
+}
@@ -0,0 +1,51 @@
+package simple
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestBatcher_failure(t *testing.T) {
+	svc := setupDB(t)
+	b := &batcher{db: svc.db, maxSize: 3, maxDelay: time.Hour}
+
+	errFail := errors.New("fail")
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		key := []byte{byte(i)}
+		go func() {
+			errs <- b.update(func(tx *bolt.Tx) error {
+				if key[0] == 1 {
+					return errFail
+				}
+				return tx.Bucket([]byte(svc.deviceBucket)).Put(key, key)
+			})
+		}()
+	}
+	var failed int
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err == errFail {
+			failed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("want 1 failed call, have %d", failed)
+	}
+	err := svc.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(svc.deviceBucket))
+		for _, key := range [][]byte{{0}, {2}} {
+			if bkt.Get(key) == nil {
+				t.Errorf("call %d was not committed", key[0])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+package simple
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// DeviceBucket is the *bolt.DB bucket where the enrollment state of every
+// device is kept, keyed by UDID.
+const DeviceBucket = "mdm.Checkin.DEVICES"
+
+// ErrDeviceNotFound is returned for devices which never checked in.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// Device is the enrollment state of a device, built from its check-ins.
+type Device struct {
+	UDID         string
+	SerialNumber string
+	ProductName  string
+	Model        string
+	OSVersion    string
+	BuildVersion string
+	DeviceName   string
+
+	// Topic, Token and PushMagic are what the device needs to be sent a
+	// push notification, from its last device channel TokenUpdate.
+	Topic     string
+	Token     []byte
+	PushMagic string
+
+	// Enrolled is set by a device channel TokenUpdate, and cleared by an
+	// Authenticate or a CheckOut.
+	Enrolled   bool
+	EnrolledAt time.Time
+
+	LastMessageType string
+	LastSeen        time.Time
+}
+
+// updateDevice applies an event to the state of its device, kept in the
+// named bucket.
+func updateDevice(tx *bolt.Tx, bucket string, event *checkin.Event) error {
+	cmd := event.Command
+	if cmd.UDID == "" {
+		return nil
+	}
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return fmt.Errorf("bucket %q not found!", bucket)
+	}
+	var d Device
+	if v := bkt.Get([]byte(cmd.UDID)); v != nil {
+		if err := json.Unmarshal(v, &d); err != nil {
+			return fmt.Errorf("device %s: %s", cmd.UDID, err)
+		}
+	}
+	d.UDID = cmd.UDID
+	d.LastMessageType = cmd.MessageType
+	d.LastSeen = event.Time
+	if cmd.Topic != "" {
+		d.Topic = cmd.Topic
+	}
+	switch cmd.MessageType {
+	case "Authenticate":
+		d.SerialNumber = cmd.SerialNumber
+		d.ProductName = cmd.ProductName
+		d.Model = cmd.Model
+		d.OSVersion = cmd.OSVersion
+		d.BuildVersion = cmd.BuildVersion
+		d.DeviceName = cmd.DeviceName
+		d.Enrolled = false
+	case "TokenUpdate":
+		// user channel TokenUpdates don't change the device channel.
+		if cmd.UserID != "" {
+			break
+		}
+		d.Token = cmd.Token
+		d.PushMagic = cmd.PushMagic
+		if !d.Enrolled {
+			d.Enrolled = true
+			d.EnrolledAt = event.Time
+		}
+	case "CheckOut":
+		d.Enrolled = false
+	}
+	data, err := json.Marshal(&d)
+	if err != nil {
+		return err
+	}
+	return bkt.Put([]byte(cmd.UDID), data)
+}
+
+// Device returns the enrollment state of the device with the given UDID.
+func (svc *CheckinService) Device(udid string) (*Device, error) {
+	var d *Device
+	err := svc.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(svc.deviceBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", svc.deviceBucket)
+		}
+		v := bkt.Get([]byte(udid))
+		if v == nil {
+			return ErrDeviceNotFound
+		}
+		d = new(Device)
+		return json.Unmarshal(v, d)
+	})
+	return d, err
+}
+
+// ForEachDevice calls fn for every device, ordered by UDID. Iteration stops
+// at the first error returned by fn, which is returned by ForEachDevice
+// unless it is ErrStopIteration.
+func ForEachDevice(db *bolt.DB, fn func(*Device) error) error {
+	return forEachDevice(db, DeviceBucket, fn)
+}
+
+func forEachDevice(db *bolt.DB, bucket string, fn func(*Device) error) error {
+	err := db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", bucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var d Device
+			if err := json.Unmarshal(v, &d); err != nil {
+				return fmt.Errorf("device %s: %s", k, err)
+			}
+			return fn(&d)
+		})
+	})
+	if err == ErrStopIteration {
+		return nil
+	}
+	return err
+}
+
+// ForEachDevice calls fn for every device of the service, like the package
+// level ForEachDevice.
+func (svc *CheckinService) ForEachDevice(fn func(*Device) error) error {
+	return forEachDevice(svc.db, svc.deviceBucket, fn)
+}
+
+// ForEachEvent calls the package level ForEachEvent with the service's
+// *bolt.DB. The filter's Bucket defaults to the service's archive bucket.
+func (svc *CheckinService) ForEachEvent(filter EventFilter, fn func(*checkin.Event) error) error {
+	if filter.Bucket == "" {
+		filter.Bucket = svc.bucket
+	}
+	return ForEachEvent(svc.db, filter, fn)
+}
+
+// ListEvents calls the package level ListEvents with the service's *bolt.DB.
+// The filter's Bucket defaults to the service's archive bucket.
+func (svc *CheckinService) ListEvents(filter EventFilter, cursor string, limit int) (*EventPage, error) {
+	if filter.Bucket == "" {
+		filter.Bucket = svc.bucket
+	}
+	return ListEvents(svc.db, filter, cursor, limit)
+}
+
+// Unenroll force-unenrolls a device, as if it had sent a CheckOut. The
+// CheckOut event is archived and published like any other, so that
+// consumers stop managing the device.
+func (svc *CheckinService) Unenroll(ctx context.Context, udid string) error {
+	d, err := svc.Device(udid)
+	if err != nil {
+		return err
+	}
+	return svc.CheckOut(ctx, mdm.CheckinCommand{
+		MessageType: "CheckOut",
+		Topic:       d.Topic,
+		UDID:        udid,
+	})
+}
@@ -0,0 +1,60 @@
+package simple
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDevices(t *testing.T) {
+	svc := setupDB(t, WithPublisher(&keyedPublisher{}))
+	ctx := context.Background()
+	auth := mustLoadCommand(t, "Authenticate")
+	update := mustLoadCommand(t, "TokenUpdate")
+	userUpdate := update
+	userUpdate.UserID = "some-user"
+	userUpdate.Token = []byte("user-token")
+
+	steps := []struct {
+		checkin      func() error
+		wantEnrolled bool
+	}{
+		{func() error { return svc.Authenticate(ctx, auth) }, false},
+		{func() error { return svc.TokenUpdate(ctx, update) }, true},
+		{func() error { return svc.TokenUpdate(ctx, userUpdate) }, true},
+		{func() error { return svc.CheckOut(ctx, mustLoadCommand(t, "CheckOut")) }, false},
+	}
+	for i, step := range steps {
+		if err := step.checkin(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		d, err := svc.Device(auth.UDID)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if d.Enrolled != step.wantEnrolled {
+			t.Errorf("step %d: want enrolled %v, have %v", i, step.wantEnrolled, d.Enrolled)
+		}
+		if d.SerialNumber != auth.SerialNumber {
+			t.Errorf("step %d: want serial number %q, have %q", i, auth.SerialNumber, d.SerialNumber)
+		}
+		if i > 0 && string(d.Token) != string(update.Token) {
+			t.Errorf("step %d: device token changed to %q", i, d.Token)
+		}
+
+		stats, err := svc.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantEnrolled := 0
+		if step.wantEnrolled {
+			wantEnrolled = 1
+		}
+		if stats.EnrolledDevices != wantEnrolled || stats.ArchivedEvents != i+1 {
+			t.Errorf("step %d: unexpected stats %+v", i, stats)
+		}
+	}
+
+	if _, err := svc.Device("unknown"); err != ErrDeviceNotFound {
+		t.Errorf("want ErrDeviceNotFound, have %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package simple
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+	nsq "github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/nsqd"
+)
+
+// EmbeddedNSQConfig configures an nsqd running inside the current process.
+type EmbeddedNSQConfig struct {
+	// DataPath is the directory where nsqd persists queued messages
+	// and topic metadata. It is created if it does not exist.
+	DataPath string
+
+	// TCPAddress and HTTPAddress are the addresses nsqd listens on.
+	// Both default to a random port on the loopback interface.
+	TCPAddress  string
+	HTTPAddress string
+}
+
+// EmbeddedNSQ is an nsqd running inside the current process, along with a
+// producer connected to it.
+// Consumers in the same process (or any other NSQ client) can subscribe to
+// the Check-in topics using TCPAddr.
+type EmbeddedNSQ struct {
+	nsqd     *nsqd.NSQD
+	producer *nsq.Producer
+
+	// done receives the error returned by nsqd.Main once nsqd exits.
+	done chan error
+}
+
+// StartEmbeddedNSQ starts an in-process nsqd and connects a producer to it.
+// It uses the API of nsqd v1.3.0, where New opens the listeners and Main
+// serves until Exit is called.
+func StartEmbeddedNSQ(config EmbeddedNSQConfig) (*EmbeddedNSQ, error) {
+	if config.DataPath == "" {
+		return nil, fmt.Errorf("embedded nsqd: data path is required")
+	}
+	if err := os.MkdirAll(config.DataPath, 0755); err != nil {
+		return nil, fmt.Errorf("embedded nsqd: create data path: %s", err)
+	}
+
+	opts := nsqd.NewOptions()
+	opts.DataPath = config.DataPath
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	if config.TCPAddress != "" {
+		opts.TCPAddress = config.TCPAddress
+	}
+	if config.HTTPAddress != "" {
+		opts.HTTPAddress = config.HTTPAddress
+	}
+
+	n, err := nsqd.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("embedded nsqd: %s", err)
+	}
+	if err := n.LoadMetadata(); err != nil {
+		n.Exit()
+		return nil, fmt.Errorf("embedded nsqd: load metadata: %s", err)
+	}
+	if err := n.PersistMetadata(); err != nil {
+		n.Exit()
+		return nil, fmt.Errorf("embedded nsqd: persist metadata: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- n.Main()
+	}()
+
+	producer, err := nsq.NewProducer(n.RealTCPAddr().String(), nsq.NewConfig())
+	if err != nil {
+		n.Exit()
+		<-done
+		return nil, fmt.Errorf("embedded nsqd: create producer: %s", err)
+	}
+	if err := producer.Ping(); err != nil {
+		producer.Stop()
+		n.Exit()
+		if mainErr := <-done; mainErr != nil {
+			err = mainErr
+		}
+		return nil, fmt.Errorf("embedded nsqd: connect producer: %s", err)
+	}
+	return &EmbeddedNSQ{nsqd: n, producer: producer, done: done}, nil
+}
+
+// Producer returns the producer connected to the embedded nsqd.
+func (e *EmbeddedNSQ) Producer() *nsq.Producer {
+	return e.producer
+}
+
+// TCPAddr returns the address NSQ consumers should connect to.
+func (e *EmbeddedNSQ) TCPAddr() string {
+	return e.nsqd.RealTCPAddr().String()
+}
+
+// HTTPAddr returns the address of the nsqd HTTP API.
+func (e *EmbeddedNSQ) HTTPAddr() string {
+	return e.nsqd.RealHTTPAddr().String()
+}
+
+// Stop stops the producer and shuts down nsqd, flushing in-memory messages
+// to the data path. It returns the error which nsqd exited with, if any.
+func (e *EmbeddedNSQ) Stop() error {
+	e.producer.Stop()
+	e.nsqd.Exit()
+	return <-e.done
+}
+
+// NewEmbeddedService creates a CheckinService which publishes to an
+// in-process nsqd, configured with opts like NewService. The caller is
+// responsible for stopping the returned EmbeddedNSQ after the service is no
+// longer used.
+func NewEmbeddedService(db *bolt.DB, config EmbeddedNSQConfig, opts ...Option) (*CheckinService, *EmbeddedNSQ, error) {
+	embedded, err := StartEmbeddedNSQ(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	svc, err := NewService(db, embedded.Producer(), opts...)
+	if err != nil {
+		embedded.Stop()
+		return nil, nil, err
+	}
+	return svc, embedded, nil
+}
@@ -0,0 +1,76 @@
+package simple
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/micromdm/checkin"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+func TestEmbeddedService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkin-embedded-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "checkin.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var observed int
+	svc, embedded, err := NewEmbeddedService(db, EmbeddedNSQConfig{
+		DataPath: filepath.Join(dir, "nsqd"),
+	}, WithBucketPrefix("embedded."), WithObserver(func(*checkin.Event) { observed++ }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer embedded.Stop()
+
+	events := make(chan *checkin.Event, 1)
+	consumer, err := nsq.NewConsumer(AuthenticateTopic, "test", nsq.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		var event checkin.Event
+		if err := checkin.UnmarshalEvent(m.Body, &event); err != nil {
+			return err
+		}
+		events <- &event
+		return nil
+	}))
+	if err := consumer.ConnectToNSQD(embedded.TCPAddr()); err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+
+	cmd := mustLoadCommand(t, "Authenticate")
+	if err := svc.Authenticate(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if !reflect.DeepEqual(event.Command, cmd) {
+			t.Errorf("\nwant: %#v\n,\nhave: %#v\n", cmd, event.Command)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for published event")
+	}
+	if observed != 1 {
+		t.Errorf("want 1 observed event, have %d", observed)
+	}
+	if svc.bucket != "embedded."+CheckinBucket {
+		t.Errorf("options not applied: archive bucket %q", svc.bucket)
+	}
+}
@@ -0,0 +1,117 @@
+package simple
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"golang.org/x/net/context"
+)
+
+// Metrics are updated by a CheckinService for every check-in. Nil metrics
+// are ignored.
+type Metrics struct {
+	// ArchiveDuration observes the time taken to archive an event, in
+	// seconds.
+	ArchiveDuration metrics.Histogram
+
+	// PublishDuration observes the time taken to publish an event, in
+	// seconds, labeled by "topic".
+	PublishDuration metrics.Histogram
+}
+
+// WithMetrics records the archive and publish timings of every check-in.
+func WithMetrics(m Metrics) Option {
+	return func(svc *CheckinService) {
+		if m.ArchiveDuration == nil {
+			m.ArchiveDuration = discard.NewHistogram()
+		}
+		if m.PublishDuration == nil {
+			m.PublishDuration = discard.NewHistogram()
+		}
+		svc.metrics = m
+	}
+}
+
+// Stats describes the contents of the database of a CheckinService.
+type Stats struct {
+	ArchivedEvents int
+
+	// ArchiveBytes is the space used by the archive bucket's pages.
+	ArchiveBytes int
+
+	EnrolledDevices int
+	DeadLetters     int
+}
+
+// Stats returns the current Stats. It reads every device, so it should be
+// called periodically rather than for every check-in.
+func (svc *CheckinService) Stats() (Stats, error) {
+	var stats Stats
+	err := svc.db.View(func(tx *bolt.Tx) error {
+		archive := tx.Bucket([]byte(svc.bucket))
+		if archive == nil {
+			return fmt.Errorf("bucket %q not found!", svc.bucket)
+		}
+		bs := archive.Stats()
+		stats.ArchivedEvents = bs.KeyN
+		stats.ArchiveBytes = bs.BranchInuse + bs.LeafInuse
+		stats.DeadLetters = tx.Bucket([]byte(svc.deadLetterBucket)).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = svc.ForEachDevice(func(d *Device) error {
+		if d.Enrolled {
+			stats.EnrolledDevices++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// StatsGauges are set to the values of Stats by ReportStats. Nil gauges are
+// ignored.
+type StatsGauges struct {
+	ArchivedEvents  metrics.Gauge
+	ArchiveBytes    metrics.Gauge
+	EnrolledDevices metrics.Gauge
+	DeadLetters     metrics.Gauge
+}
+
+// ReportStats sets the gauges to the current Stats every interval, until
+// ctx is done. Failures to read the stats are returned on errc, if it is
+// not nil; sending on errc is abandoned when ctx is done.
+func (svc *CheckinService) ReportStats(ctx context.Context, g StatsGauges, interval time.Duration, errc chan<- error) {
+	set := func(g metrics.Gauge, v int) {
+		if g != nil {
+			g.Set(float64(v))
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := svc.Stats()
+		if err != nil && errc != nil {
+			select {
+			case errc <- err:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err == nil {
+			set(g.ArchivedEvents, stats.ArchivedEvents)
+			set(g.ArchiveBytes, stats.ArchiveBytes)
+			set(g.EnrolledDevices, stats.EnrolledDevices)
+			set(g.DeadLetters, stats.DeadLetters)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
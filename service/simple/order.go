@@ -0,0 +1,71 @@
+package simple
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// SequenceBucket is the *bolt.DB bucket where the last sequence number
+// assigned to each device is kept, keyed by UDID.
+const SequenceBucket = "mdm.Checkin.SEQUENCE"
+
+// KeyedPublisher is a Publisher for brokers which partition a topic by key,
+// such as Kafka. Messages published with the same key are delivered in
+// order. If the publisher passed to a CheckinService is a KeyedPublisher,
+// events are published with the device UDID as key.
+type KeyedPublisher interface {
+	Publisher
+	PublishWithKey(topic, key string, body []byte) error
+}
+
+// WithSingleTopic publishes every event to topic, instead of a topic per
+// message type, so that all the events of a device are in one stream.
+// Concurrent check-ins of a device may be published out of order, so
+// consumers order the events of a device by their Sequence.
+func WithSingleTopic(topic string) Option {
+	return func(svc *CheckinService) {
+		svc.topic = topic
+	}
+}
+
+// publish publishes body to topic, with key if p is a KeyedPublisher.
+func publish(p Publisher, topic, key string, body []byte) error {
+	if kp, ok := p.(KeyedPublisher); ok && key != "" {
+		return kp.PublishWithKey(topic, key, body)
+	}
+	return p.Publish(topic, body)
+}
+
+// nextSequence increments and returns the sequence number of a device,
+// kept in the named bucket.
+func nextSequence(tx *bolt.Tx, bucket, udid string) (uint64, error) {
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return 0, fmt.Errorf("bucket %q not found!", bucket)
+	}
+	var seq uint64
+	if v := bkt.Get([]byte(udid)); v != nil {
+		seq = binary.BigEndian.Uint64(v)
+	}
+	seq++
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], seq)
+	return seq, bkt.Put([]byte(udid), v[:])
+}
+
+// deviceLocks serializes the check-ins of a device, so that its events are
+// archived in the order of their sequence numbers. Devices share a fixed
+// number of locks.
+type deviceLocks [64]sync.Mutex
+
+func (l *deviceLocks) lock(udid string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(udid))
+	mu := &l[h.Sum32()%uint32(len(l))]
+	mu.Lock()
+	return mu.Unlock
+}
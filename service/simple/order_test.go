@@ -0,0 +1,84 @@
+package simple
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+)
+
+func TestSequence(t *testing.T) {
+	pub := &keyedPublisher{}
+	svc := setupDB(t, WithPublisher(pub), WithSingleTopic("mdm.Checkin"), WithRetry(DefaultRetryPolicy, PublishMetrics{}))
+	ctx := context.Background()
+
+	other := mustLoadCommand(t, "TokenUpdate")
+	other.UDID = "other-device"
+	checkins := []struct {
+		fn  func(context.Context, mdm.CheckinCommand) error
+		cmd mdm.CheckinCommand
+	}{
+		{svc.Authenticate, mustLoadCommand(t, "Authenticate")},
+		{svc.TokenUpdate, mustLoadCommand(t, "TokenUpdate")},
+		{svc.TokenUpdate, other},
+		{svc.CheckOut, mustLoadCommand(t, "CheckOut")},
+	}
+	for _, c := range checkins {
+		if err := c.fn(ctx, c.cmd); err != nil {
+			t.Fatalf("%s error = %v", c.cmd.MessageType, err)
+		}
+	}
+
+	want := []published{
+		{"mdm.Checkin", checkins[0].cmd.UDID, 1},
+		{"mdm.Checkin", checkins[1].cmd.UDID, 2},
+		{"mdm.Checkin", "other-device", 1},
+		{"mdm.Checkin", checkins[3].cmd.UDID, 3},
+	}
+	if len(pub.published) != len(want) {
+		t.Fatalf("want %d published events, have %d", len(want), len(pub.published))
+	}
+	for i, have := range pub.published {
+		if have != want[i] {
+			t.Errorf("event %d: want %+v, have %+v", i, want[i], have)
+		}
+	}
+
+	// the sequence numbers of the archived events match the published ones.
+	var seqs []uint64
+	err := ForEachEvent(svc.db, EventFilter{UDID: checkins[0].cmd.UDID}, func(e *checkin.Event) error {
+		seqs = append(seqs, e.Sequence)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 3 {
+		t.Errorf("want archived sequence numbers [1 2 3], have %v", seqs)
+	}
+}
+
+type published struct {
+	topic    string
+	key      string
+	sequence uint64
+}
+
+// keyedPublisher records the events published with a key.
+type keyedPublisher struct {
+	published []published
+}
+
+func (p *keyedPublisher) Publish(topic string, body []byte) error {
+	return p.PublishWithKey(topic, "", body)
+}
+
+func (p *keyedPublisher) PublishWithKey(topic, key string, body []byte) error {
+	var event checkin.Event
+	if err := checkin.UnmarshalEvent(body, &event); err != nil {
+		return err
+	}
+	p.published = append(p.published, published{topic, key, event.Sequence})
+	return nil
+}
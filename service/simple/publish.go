@@ -0,0 +1,390 @@
+package simple
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+	"golang.org/x/net/context"
+)
+
+// DeadLetterBucket is the *bolt.DB bucket where events which could not be
+// published are kept until they are re-driven.
+const DeadLetterBucket = "mdm.Checkin.DEADLETTER"
+
+// ErrDeadLetterNotFound is returned when a dead-lettered event does not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// errCircuitOpen is returned instead of publishing while a topic's circuit
+// breaker is open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// RetryPolicy configures how failed publishes are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a publish is attempted,
+	// including the first attempt.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It doubles
+	// after every failed attempt, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// After BreakerThreshold consecutive failed publishes to a topic,
+	// the topic's circuit breaker opens and events for it are
+	// dead-lettered without attempting to publish, until BreakerCooldown
+	// has passed. Then a single trial publish is let through, which
+	// closes the breaker if it succeeds and re-opens it if it fails.
+	// A zero BreakerThreshold disables the circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultRetryPolicy retries a publish for about 1.5 seconds before
+// dead-lettering the event.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      5,
+	InitialBackoff:   100 * time.Millisecond,
+	MaxBackoff:       2 * time.Second,
+	BreakerThreshold: 10,
+	BreakerCooldown:  30 * time.Second,
+}
+
+// PublishMetrics are updated by the retrying publisher. All counters have a
+// single label, "topic". Nil counters are ignored.
+type PublishMetrics struct {
+	// Failures counts failed publish attempts.
+	Failures metrics.Counter
+
+	// DeadLettered counts events stored in the dead letter bucket.
+	DeadLettered metrics.Counter
+}
+
+// WithRetry retries failed publishes according to policy.
+// An event which still can't be published is stored in the dead letter
+// bucket and the check-in succeeds, because the event is archived and can be
+// re-driven with Redrive. The check-in fails only if the event can't be
+// dead-lettered either. A publish is attempted at least once, even if
+// policy.MaxAttempts is less than 1.
+//
+// Retries happen while the device waits for the response to its check-in,
+// once for every topic the event is routed to. They stop as soon as the
+// context of the check-in is done, or when the next backoff would end after
+// its deadline, and the event is dead-lettered. Keep the policy short, and
+// re-drive dead letters in the background for longer outages.
+func WithRetry(policy RetryPolicy, m PublishMetrics) Option {
+	return func(svc *CheckinService) {
+		if policy.MaxAttempts < 1 {
+			policy.MaxAttempts = 1
+		}
+		if m.Failures == nil {
+			m.Failures = discard.NewCounter()
+		}
+		if m.DeadLettered == nil {
+			m.DeadLettered = discard.NewCounter()
+		}
+		svc.retry = &retryPublisher{
+			db:       svc.db,
+			policy:   policy,
+			metrics:  m,
+			breakers: make(map[string]*breaker),
+			sleep:    sleepContext,
+		}
+	}
+}
+
+// DeadLetter is an event which could not be published to a topic.
+type DeadLetter struct {
+	// ID identifies the dead letter. It is the event ID and the topic,
+	// separated by a slash, because an event routed to several topics
+	// can be dead-lettered once for each.
+	ID       string
+	Topic    string
+	Key      string
+	Event    *checkin.Event `json:"-"`
+	Message  []byte
+	Attempts int
+	Error    string
+	Time     time.Time
+}
+
+// retryPublisher publishes to the next Publisher, retrying failed publishes
+// and dead-lettering events which exhaust their retries.
+type retryPublisher struct {
+	next    Publisher
+	db      *bolt.DB
+	bucket  string
+	policy  RetryPolicy
+	metrics PublishMetrics
+	sleep   func(context.Context, time.Duration) error
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func (p *retryPublisher) Publish(topic string, msg []byte) error {
+	return p.publishContext(context.Background(), topic, "", msg)
+}
+
+// PublishWithKey implements KeyedPublisher. The key is passed on if the
+// next Publisher is a KeyedPublisher.
+func (p *retryPublisher) PublishWithKey(topic, key string, msg []byte) error {
+	return p.publishContext(context.Background(), topic, key, msg)
+}
+
+// publishContext publishes msg, retrying until the retries are exhausted
+// or ctx is done, and dead-letters it if it could not be published.
+func (p *retryPublisher) publishContext(ctx context.Context, topic, key string, msg []byte) error {
+	attempts, err := p.publish(ctx, topic, key, msg)
+	if err == nil {
+		return nil
+	}
+	letter := DeadLetter{
+		Topic:    topic,
+		Key:      key,
+		Message:  msg,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now().UTC(),
+	}
+	if dlErr := p.deadLetter(&letter); dlErr != nil {
+		return fmt.Errorf("publish to %s: %s, dead letter: %s", topic, err, dlErr)
+	}
+	p.metrics.DeadLettered.With("topic", topic).Add(1)
+	return nil
+}
+
+// publish attempts to publish msg, returning the number of attempts made
+// and the last error.
+func (p *retryPublisher) publish(ctx context.Context, topic, key string, msg []byte) (int, error) {
+	b := p.breaker(topic)
+	backoff := p.policy.InitialBackoff
+	var err error
+	for attempt := 1; attempt <= p.policy.MaxAttempts; attempt++ {
+		if !b.allow() {
+			return attempt - 1, errCircuitOpen
+		}
+		if err = publish(p.next, topic, key, msg); err == nil {
+			b.success()
+			return attempt, nil
+		}
+		p.metrics.Failures.With("topic", topic).Add(1)
+		b.failure()
+		if attempt == p.policy.MaxAttempts {
+			break
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return attempt, err
+		}
+		if sleepErr := p.sleep(ctx, backoff); sleepErr != nil {
+			return attempt, err
+		}
+		if backoff *= 2; backoff > p.policy.MaxBackoff {
+			backoff = p.policy.MaxBackoff
+		}
+	}
+	return p.policy.MaxAttempts, err
+}
+
+func (p *retryPublisher) breaker(topic string) *breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.breakers[topic]
+	if !ok {
+		b = &breaker{
+			threshold: p.policy.BreakerThreshold,
+			cooldown:  p.policy.BreakerCooldown,
+		}
+		p.breakers[topic] = b
+	}
+	return b
+}
+
+// sleepContext waits for d, or until ctx is done, returning ctx.Err().
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deadLetter assigns the letter's ID and stores it.
+func (p *retryPublisher) deadLetter(letter *DeadLetter) error {
+	var event checkin.Event
+	if err := checkin.UnmarshalEvent(letter.Message, &event); err != nil {
+		return err
+	}
+	letter.ID = event.ID + "/" + letter.Topic
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(p.bucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", p.bucket)
+		}
+		return bkt.Put([]byte(letter.ID), data)
+	})
+}
+
+// breaker is a circuit breaker which opens after a number of consecutive
+// failures. After the cooldown it is half-open: a single trial request is
+// allowed, which closes the breaker if it succeeds and re-opens it if it
+// fails. Other requests are rejected until the trial finishes.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request of the half-open breaker is in flight
+}
+
+func (b *breaker) allow() bool {
+	if b.threshold == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.trial = false
+	b.mu.Unlock()
+}
+
+func (b *breaker) failure() {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// DeadLetters returns all dead-lettered events, ordered by ID.
+func (svc *CheckinService) DeadLetters() ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := svc.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(svc.deadLetterBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", svc.deadLetterBucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			letter, err := unmarshalDeadLetter(v)
+			if err != nil {
+				return fmt.Errorf("dead letter %s: %s", k, err)
+			}
+			letters = append(letters, *letter)
+			return nil
+		})
+	})
+	return letters, err
+}
+
+// DeadLetter returns the dead letter with the given ID.
+func (svc *CheckinService) DeadLetter(id string) (*DeadLetter, error) {
+	var letter *DeadLetter
+	err := svc.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(svc.deadLetterBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", svc.deadLetterBucket)
+		}
+		v := bkt.Get([]byte(id))
+		if v == nil {
+			return ErrDeadLetterNotFound
+		}
+		var err error
+		letter, err = unmarshalDeadLetter(v)
+		return err
+	})
+	return letter, err
+}
+
+// Redrive publishes a dead-lettered event again, without retrying, and
+// removes it from the dead letter bucket if the publish succeeds.
+// A failed publish is recorded on the dead letter.
+func (svc *CheckinService) Redrive(id string) error {
+	letter, err := svc.DeadLetter(id)
+	if err != nil {
+		return err
+	}
+	pub := svc.publisher
+	if svc.retry != nil {
+		pub = svc.retry.next
+	}
+
+	if pubErr := publish(pub, letter.Topic, letter.Key, letter.Message); pubErr != nil {
+		letter.Attempts++
+		letter.Error = pubErr.Error()
+		data, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		err = svc.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(svc.deadLetterBucket)).Put([]byte(id), data)
+		})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("redrive %s: %s", id, pubErr)
+	}
+	return svc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(svc.deadLetterBucket)).Delete([]byte(id))
+	})
+}
+
+// RedriveAll re-drives every dead-lettered event, returning the number of
+// events published and the first error.
+func (svc *CheckinService) RedriveAll() (int, error) {
+	letters, err := svc.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, letter := range letters {
+		if err := svc.Redrive(letter.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func unmarshalDeadLetter(data []byte) (*DeadLetter, error) {
+	var letter DeadLetter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return nil, err
+	}
+	letter.Event = new(checkin.Event)
+	if err := checkin.UnmarshalEvent(letter.Message, letter.Event); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
@@ -0,0 +1,229 @@
+package simple
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/net/context"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // number of failed publishes before one succeeds
+		policy       RetryPolicy
+		wantCalls    int
+		deadLettered bool
+	}{
+		{
+			name:      "first_attempt",
+			policy:    RetryPolicy{MaxAttempts: 3},
+			wantCalls: 1,
+		},
+		{
+			name:      "transient_failure",
+			failures:  2,
+			policy:    RetryPolicy{MaxAttempts: 3},
+			wantCalls: 3,
+		},
+		{
+			name:         "retries_exhausted",
+			failures:     3,
+			policy:       RetryPolicy{MaxAttempts: 3},
+			wantCalls:    3,
+			deadLettered: true,
+		},
+		{
+			name:      "zero_value_policy",
+			wantCalls: 1,
+		},
+		{
+			name:         "zero_value_policy_failure",
+			failures:     1,
+			wantCalls:    1,
+			deadLettered: true,
+		},
+		{
+			name:         "breaker_opens",
+			failures:     3,
+			policy:       RetryPolicy{MaxAttempts: 3, BreakerThreshold: 2, BreakerCooldown: time.Hour},
+			wantCalls:    2,
+			deadLettered: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &flakyPublisher{failures: tt.failures}
+			failures, deadLettered := newTestCounter(), newTestCounter()
+			svc := setupDB(t, WithPublisher(pub), WithRetry(tt.policy, PublishMetrics{
+				Failures:     failures,
+				DeadLettered: deadLettered,
+			}))
+			svc.retry.sleep = func(context.Context, time.Duration) error { return nil }
+
+			if err := svc.TokenUpdate(context.Background(), mustLoadCommand(t, "TokenUpdate")); err != nil {
+				t.Fatalf("TokenUpdate error = %v", err)
+			}
+			if want, have := tt.wantCalls, pub.calls; want != have {
+				t.Errorf("want %d publish calls, have %d", want, have)
+			}
+			wantFailures := tt.failures
+			if wantFailures > tt.wantCalls {
+				wantFailures = tt.wantCalls
+			}
+			if want, have := float64(wantFailures), failures.value(TokenUpdateTopic); want != have {
+				t.Errorf("want %v failures counted, have %v", want, have)
+			}
+
+			letters, err := svc.DeadLetters()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.deadLettered {
+				if len(letters) != 0 {
+					t.Fatalf("want no dead letters, have %d", len(letters))
+				}
+				return
+			}
+			if len(letters) != 1 {
+				t.Fatalf("want 1 dead letter, have %d", len(letters))
+			}
+			if want, have := TokenUpdateTopic, letters[0].Topic; want != have {
+				t.Errorf("want topic %q, have %q", want, have)
+			}
+			if want, have := float64(1), deadLettered.value(TokenUpdateTopic); want != have {
+				t.Errorf("want %v dead letters counted, have %v", want, have)
+			}
+		})
+	}
+}
+
+func TestRetry_contextDone(t *testing.T) {
+	pub := &flakyPublisher{failures: 3}
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	svc := setupDB(t, WithPublisher(pub), WithRetry(policy, PublishMetrics{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := svc.TokenUpdate(ctx, mustLoadCommand(t, "TokenUpdate")); err != nil {
+		t.Fatalf("TokenUpdate error = %v", err)
+	}
+	if want, have := 1, pub.calls; want != have {
+		t.Errorf("want %d publish calls, have %d", want, have)
+	}
+	letters, err := svc.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Errorf("want 1 dead letter, have %d", len(letters))
+	}
+}
+
+func TestBreaker_halfOpen(t *testing.T) {
+	b := &breaker{threshold: 1, cooldown: time.Hour}
+	b.failure()
+	if b.allow() {
+		t.Fatal("open breaker allowed a request")
+	}
+	b.openUntil = time.Now() // the cooldown has passed
+	if !b.allow() {
+		t.Fatal("half-open breaker rejected the trial request")
+	}
+	if b.allow() {
+		t.Error("half-open breaker allowed a second request during the trial")
+	}
+	b.failure()
+	if b.allow() {
+		t.Error("failed trial did not re-open the breaker")
+	}
+	b.openUntil = time.Now()
+	if !b.allow() {
+		t.Fatal("half-open breaker rejected the trial request")
+	}
+	b.success()
+	if !b.allow() || !b.allow() {
+		t.Error("successful trial did not close the breaker")
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	pub := &flakyPublisher{failures: 2}
+	svc := setupDB(t, WithPublisher(pub), WithRetry(RetryPolicy{MaxAttempts: 1}, PublishMetrics{}))
+
+	if err := svc.CheckOut(context.Background(), mustLoadCommand(t, "CheckOut")); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := svc.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("want 1 dead letter, have %d", len(letters))
+	}
+	id := letters[0].ID
+	if want := letters[0].Event.ID + "/" + CheckoutTopic; id != want {
+		t.Errorf("want dead letter ID %q, have %q", want, id)
+	}
+
+	// the second publish still fails, and is recorded on the dead letter.
+	if err := svc.Redrive(id); err == nil {
+		t.Fatal("want redrive error")
+	}
+	letter, err := svc.DeadLetter(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, letter.Attempts; want != have {
+		t.Errorf("want %d attempts, have %d", want, have)
+	}
+
+	if n, err := svc.RedriveAll(); err != nil || n != 1 {
+		t.Fatalf("RedriveAll = %d, %v", n, err)
+	}
+	if _, err := svc.DeadLetter(id); err != ErrDeadLetterNotFound {
+		t.Errorf("want ErrDeadLetterNotFound, have %v", err)
+	}
+	if want, have := CheckoutTopic, pub.topic; want != have {
+		t.Errorf("want redrive to %q, have %q", want, have)
+	}
+}
+
+type flakyPublisher struct {
+	failures int
+	calls    int
+	topic    string
+}
+
+func (p *flakyPublisher) Publish(topic string, msg []byte) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("nsqd unavailable")
+	}
+	p.topic = topic
+	return nil
+}
+
+// testCounter is a metrics.Counter which records values by topic label.
+type testCounter struct {
+	values map[string]float64
+	topic  string
+}
+
+func newTestCounter() *testCounter {
+	return &testCounter{values: make(map[string]float64)}
+}
+
+func (c *testCounter) With(labelValues ...string) metrics.Counter {
+	return &testCounter{values: c.values, topic: labelValues[1]}
+}
+
+func (c *testCounter) Add(delta float64) {
+	c.values[c.topic] += delta
+}
+
+func (c *testCounter) value(topic string) float64 {
+	return c.values[topic]
+}
@@ -0,0 +1,204 @@
+package simple
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"text/template"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// RoutingConfig configures the topics a CheckinService publishes events to.
+//
+// Topics are text/template templates executed with a RouteData value, for
+// example "mdm.{{.Tenant}}.{{.MessageType}}". An event is published to the
+// topics of its message type and to the topics of every rule it matches,
+// each topic at most once.
+type RoutingConfig struct {
+	// Prefix is prepended to every topic, to namespace environments
+	// sharing a message queue.
+	Prefix string
+
+	// Topics maps a MessageType to the topics its events are published
+	// to. Message types which are not in the map are published to
+	// AuthenticateTopic, TokenUpdateTopic or CheckoutTopic, or the topic
+	// set with WithSingleTopic.
+	Topics map[string][]string
+
+	Rules []RoutingRule
+}
+
+// RoutingRule publishes the events it matches to additional topics.
+// Empty fields match every event.
+type RoutingRule struct {
+	// MessageType matches the event's MessageType.
+	MessageType string
+
+	// Model is a path.Match pattern matched against the Model, ModelName
+	// and ProductName of the event. Only Authenticate messages have a
+	// model.
+	Model string
+
+	// OSVersion is a path.Match pattern, such as "10.12*". Only
+	// Authenticate messages have an OS version.
+	OSVersion string
+
+	// UserChannel matches user channel TokenUpdates if true, and device
+	// channel check-ins if false.
+	UserChannel *bool
+
+	Topics []string
+
+	// Replace publishes a matching event only to the rule's topics,
+	// instead of the topics of its message type.
+	Replace bool
+}
+
+// RouteData is the data topic templates are executed with.
+type RouteData struct {
+	MessageType string
+
+	// Topic is the APNs push topic of the device.
+	Topic string
+
+	UDID string
+
+	// Tenant is the tenant ID carried by the check-in context.
+	// See checkin.NewTenantContext.
+	Tenant string
+}
+
+// Router maps check-ins to the topics their events are published to.
+type Router struct {
+	prefix string
+	topics map[string][]*template.Template
+	rules  []rule
+}
+
+type rule struct {
+	RoutingRule
+	topics []*template.Template
+}
+
+// NewRouter creates a Router from config, returning an error if a rule or
+// topic template is invalid.
+func NewRouter(config RoutingConfig) (*Router, error) {
+	r := &Router{
+		prefix: config.Prefix,
+		topics: make(map[string][]*template.Template),
+	}
+	for messageType, topics := range config.Topics {
+		tmpls, err := parseTopics(topics)
+		if err != nil {
+			return nil, err
+		}
+		r.topics[messageType] = tmpls
+	}
+	for i, rr := range config.Rules {
+		for _, pattern := range []string{rr.Model, rr.OSVersion} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("routing rule %d: pattern %q: %s", i, pattern, err)
+			}
+		}
+		tmpls, err := parseTopics(rr.Topics)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %d: %s", i, err)
+		}
+		r.rules = append(r.rules, rule{RoutingRule: rr, topics: tmpls})
+	}
+	return r, nil
+}
+
+// WithRouter publishes events to the topics chosen by r.
+func WithRouter(r *Router) Option {
+	return func(svc *CheckinService) {
+		svc.router = r
+	}
+}
+
+// Route returns the topics to publish a check-in to. defaultTopic is used
+// if the router has no topics for the check-in's message type. A topic
+// template which fails to execute returns a *checkin.Error of KindInternal.
+func (r *Router) Route(ctx context.Context, cmd mdm.CheckinCommand, defaultTopic string) ([]string, error) {
+	data := RouteData{
+		MessageType: cmd.MessageType,
+		Topic:       cmd.Topic,
+		UDID:        cmd.UDID,
+	}
+	data.Tenant, _ = checkin.TenantFromContext(ctx)
+
+	tmpls, ok := r.topics[cmd.MessageType]
+	var topics []string
+	if !ok {
+		topics = []string{r.prefix + defaultTopic}
+	}
+	for _, rule := range r.rules {
+		if !rule.match(cmd) {
+			continue
+		}
+		if rule.Replace {
+			tmpls, topics = nil, nil
+		}
+		tmpls = append(tmpls[:len(tmpls):len(tmpls)], rule.topics...)
+	}
+
+	seen := make(map[string]bool)
+	for _, topic := range topics {
+		seen[topic] = true
+	}
+	for _, tmpl := range tmpls {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, &checkin.Error{
+				Kind: checkin.KindInternal,
+				Err:  fmt.Errorf("route %s: %s", cmd.MessageType, err),
+			}
+		}
+		topic := r.prefix + buf.String()
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	return topics, nil
+}
+
+func (r rule) match(cmd mdm.CheckinCommand) bool {
+	if r.MessageType != "" && r.MessageType != cmd.MessageType {
+		return false
+	}
+	if r.Model != "" && !matchAny(r.Model, cmd.Model, cmd.ModelName, cmd.ProductName) {
+		return false
+	}
+	if r.OSVersion != "" && !matchAny(r.OSVersion, cmd.OSVersion) {
+		return false
+	}
+	if r.UserChannel != nil && *r.UserChannel != (cmd.UserID != "") {
+		return false
+	}
+	return true
+}
+
+func matchAny(pattern string, values ...string) bool {
+	for _, v := range values {
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTopics(topics []string) ([]*template.Template, error) {
+	tmpls := make([]*template.Template, 0, len(topics))
+	for _, topic := range topics {
+		tmpl, err := template.New(topic).Option("missingkey=error").Parse(topic)
+		if err != nil {
+			return nil, fmt.Errorf("topic template %q: %s", topic, err)
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+	return tmpls, nil
+}
@@ -0,0 +1,119 @@
+package simple
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/mdm"
+)
+
+func TestRouter(t *testing.T) {
+	userChannel := true
+	config := RoutingConfig{
+		Prefix: "staging.",
+		Topics: map[string][]string{
+			"TokenUpdate": {"mdm.{{.Tenant}}.TokenUpdate"},
+		},
+		Rules: []RoutingRule{
+			{Model: "MacBookPro*", Topics: []string{"mdm.mac.{{.MessageType}}"}},
+			{OSVersion: "10.11*", MessageType: "Authenticate", Topics: []string{"mdm.upgrade"}},
+			{UserChannel: &userChannel, Topics: []string{"mdm.user.TokenUpdate"}, Replace: true},
+		},
+	}
+	router, err := NewRouter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userTokenUpdate := mustLoadCommand(t, "TokenUpdate")
+	userTokenUpdate.UserID = "some-user"
+	ctx := checkin.NewTenantContext(context.Background(), "acme")
+
+	tests := []struct {
+		name string
+		cmd  mdm.CheckinCommand
+		want []string
+	}{
+		{
+			name: "default_topic_and_rules",
+			cmd:  mustLoadCommand(t, "Authenticate"),
+			want: []string{"staging.mdm.Authenticate", "staging.mdm.mac.Authenticate", "staging.mdm.upgrade"},
+		},
+		{
+			name: "topic_template",
+			cmd:  mustLoadCommand(t, "TokenUpdate"),
+			want: []string{"staging.mdm.acme.TokenUpdate"},
+		},
+		{
+			name: "replace",
+			cmd:  userTokenUpdate,
+			want: []string{"staging.mdm.user.TokenUpdate"},
+		},
+		{
+			name: "no_rules",
+			cmd:  mustLoadCommand(t, "CheckOut"),
+			want: []string{"staging.mdm.CheckOut"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultTopic := "mdm." + tt.cmd.MessageType
+			have, err := router.Route(ctx, tt.cmd, defaultTopic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.want, have) {
+				t.Errorf("want topics %q, have %q", tt.want, have)
+			}
+		})
+	}
+}
+
+func TestNewRouter_invalid(t *testing.T) {
+	configs := []RoutingConfig{
+		{Topics: map[string][]string{"CheckOut": {"mdm.{{.Tenant"}}},
+		{Rules: []RoutingRule{{Model: "[", Topics: []string{"mdm.mac"}}}},
+	}
+	for _, config := range configs {
+		if _, err := NewRouter(config); err == nil {
+			t.Errorf("want error for %+v", config)
+		}
+	}
+}
+
+func TestRouter_executeError(t *testing.T) {
+	router, err := NewRouter(RoutingConfig{
+		Topics: map[string][]string{"CheckOut": {"mdm.{{.Serial}}"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = router.Route(context.Background(), mustLoadCommand(t, "CheckOut"), CheckoutTopic)
+	if kind, _ := checkin.ErrorKind(err); err == nil || kind != checkin.KindInternal {
+		t.Errorf("want internal error, have %v", err)
+	}
+}
+
+func TestService_fanOut(t *testing.T) {
+	router, err := NewRouter(RoutingConfig{
+		Topics: map[string][]string{"CheckOut": {"mdm.CheckOut", "mdm.Unenrolled"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &keyedPublisher{}
+	svc := setupDB(t, WithPublisher(pub), WithRouter(router))
+	if err := svc.CheckOut(context.Background(), mustLoadCommand(t, "CheckOut")); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.published) != 2 {
+		t.Fatalf("want event published to 2 topics, have %d", len(pub.published))
+	}
+	for i, topic := range []string{"mdm.CheckOut", "mdm.Unenrolled"} {
+		if have := pub.published[i].topic; have != topic {
+			t.Errorf("want topic %q, have %q", topic, have)
+		}
+	}
+}
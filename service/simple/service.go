@@ -2,11 +2,15 @@ package simple
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/micromdm/checkin"
 	"github.com/micromdm/mdm"
 	nsq "github.com/nsqio/go-nsq"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 	"golang.org/x/net/context"
 )
 
@@ -20,94 +24,325 @@ const (
 	CheckoutTopic     = "mdm.CheckOut"
 )
 
-// The publisher interface is satisfied by an NSQ producer.
-// Only used in tests.
-type publisher interface {
-	Publish(string, []byte) error
+// Publisher publishes Check-in events to a message queue topic.
+// The Publisher interface is satisfied by an NSQ producer.
+type Publisher interface {
+	Publish(topic string, body []byte) error
 }
 
 // archiveFunc is the function signature for archiving events in BoltDB.
+// It assigns the event's sequence number and returns the marshaled event.
 // CheckinService.archive is used outside of tests.
-type archiveFunc func(int64, []byte) error
+type archiveFunc func(int64, *checkin.Event) ([]byte, error)
 
 // CheckinService implements the MDM Check-in protocol and responds to Check-in
 // requests and publishes them to an NSQ topic.
 // The CheckinService also archives all request to a BoltDB bucket.
 type CheckinService struct {
-	db *bolt.DB
-	publisher
+	db        *bolt.DB
+	publisher Publisher
+	retry     *retryPublisher
+
+	// bucket is the archive bucket. The other buckets hold the dead
+	// letters, sequence numbers and device state of the service.
+	bucket           string
+	deadLetterBucket string
+	sequenceBucket   string
+	deviceBucket     string
+
+	// topic overrides the topic of every message type if set.
+	topic  string
+	router *Router
+	locks  deviceLocks
+
+	metrics Metrics
+
+	// observers are called with every accepted event. See WithObserver.
+	observers []func(*checkin.Event)
 
 	archiveFn archiveFunc
+	// batch groups archive transactions. See WithBatchArchive.
+	batch *batcher
+}
+
+// Option configures a CheckinService.
+type Option func(*CheckinService)
+
+// WithPublisher publishes events with p instead of an NSQ producer.
+func WithPublisher(p Publisher) Option {
+	return func(svc *CheckinService) {
+		svc.publisher = p
+	}
+}
+
+// WithBucket archives events in the named bucket instead of CheckinBucket.
+// The dead letter, sequence and device buckets are not renamed; use
+// WithBucketPrefix for services sharing a *bolt.DB.
+func WithBucket(name string) Option {
+	return func(svc *CheckinService) {
+		svc.bucket = name
+	}
+}
+
+// WithBucketPrefix prefixes the names of all the buckets of the service,
+// so that services sharing a *bolt.DB, such as the tenants of a
+// multitenant.Mux, keep separate archives, dead letters, sequence numbers
+// and devices.
+func WithBucketPrefix(prefix string) Option {
+	return func(svc *CheckinService) {
+		svc.bucket = prefix + CheckinBucket
+		svc.deadLetterBucket = prefix + DeadLetterBucket
+		svc.sequenceBucket = prefix + SequenceBucket
+		svc.deviceBucket = prefix + DeviceBucket
+	}
+}
+
+// WithBatchArchive groups events archived by concurrent check-ins into a
+// single BoltDB write transaction, so that they share one fsync instead of
+// paying for one each. A transaction is committed once it holds maxSize
+// events or maxDelay has passed since the first event was added, whichever
+// comes first. A check-in returns only after its event has been committed.
+// A maxSize or maxDelay of zero or less defaults to bolt.DefaultMaxBatchSize
+// or bolt.DefaultMaxBatchDelay.
+//
+// The batches are made by the service, so the *bolt.DB passed to NewService
+// is not reconfigured, and may be shared.
+func WithBatchArchive(maxSize int, maxDelay time.Duration) Option {
+	if maxSize <= 0 {
+		maxSize = bolt.DefaultMaxBatchSize
+	}
+	if maxDelay <= 0 {
+		maxDelay = bolt.DefaultMaxBatchDelay
+	}
+	return func(svc *CheckinService) {
+		svc.batch = &batcher{db: svc.db, maxSize: maxSize, maxDelay: maxDelay}
+		svc.archiveFn = svc.batchArchive
+	}
+}
+
+// WithObserver calls fn with every event accepted by the service, once it
+// has been archived and published. fn is called before the check-in
+// returns, and so must not block. Events may be passed to fn concurrently,
+// and those of a device out of order; order them by their Sequence.
+func WithObserver(fn func(*checkin.Event)) Option {
+	return func(svc *CheckinService) {
+		svc.observers = append(svc.observers, fn)
+	}
 }
 
-// NewService creates a CheckinService.
-func NewService(db *bolt.DB, producer *nsq.Producer) (*CheckinService, error) {
+// NewService creates a CheckinService. The producer may be nil if a
+// different publisher is configured with WithPublisher.
+func NewService(db *bolt.DB, producer *nsq.Producer, opts ...Option) (*CheckinService, error) {
+	svc := &CheckinService{
+		db:               db,
+		bucket:           CheckinBucket,
+		deadLetterBucket: DeadLetterBucket,
+		sequenceBucket:   SequenceBucket,
+		deviceBucket:     DeviceBucket,
+	}
+	WithMetrics(Metrics{})(svc)
+	if producer != nil {
+		svc.publisher = producer
+	}
+	svc.archiveFn = svc.archive
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if svc.retry != nil {
+		svc.retry.next = svc.publisher
+		svc.retry.bucket = svc.deadLetterBucket
+		svc.publisher = svc.retry
+	}
 	err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(CheckinBucket))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+		for _, name := range []string{svc.bucket, svc.deadLetterBucket, svc.sequenceBucket, svc.deviceBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		if err := createIndex(tx, svc.bucket); err != nil {
+			return fmt.Errorf("create index: %s", err)
 		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	svc := &CheckinService{db: db, publisher: producer}
-	svc.archiveFn = svc.archive
 	return svc, nil
 }
 
 func (svc *CheckinService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
 	if cmd.MessageType != "Authenticate" {
-		return fmt.Errorf("expected Authenticate, got %s MessageType", cmd.MessageType)
+		return &checkin.Error{
+			Kind: checkin.KindValidation,
+			Err:  fmt.Errorf("expected Authenticate, got %s MessageType", cmd.MessageType),
+		}
 	}
-	return svc.archiveAndPublish(AuthenticateTopic, cmd)
+	return svc.archiveAndPublish(ctx, AuthenticateTopic, cmd)
 }
 
 func (svc *CheckinService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
 	if cmd.MessageType != "TokenUpdate" {
-		return fmt.Errorf("expected TokenUpdate, got %s MessageType", cmd.MessageType)
+		return &checkin.Error{
+			Kind: checkin.KindValidation,
+			Err:  fmt.Errorf("expected TokenUpdate, got %s MessageType", cmd.MessageType),
+		}
 	}
-	return svc.archiveAndPublish(TokenUpdateTopic, cmd)
+	return svc.archiveAndPublish(ctx, TokenUpdateTopic, cmd)
 }
 
 func (svc *CheckinService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
 	if cmd.MessageType != "CheckOut" {
-		return fmt.Errorf("expected CheckOut, but got %s MessageType", cmd.MessageType)
+		return &checkin.Error{
+			Kind: checkin.KindValidation,
+			Err:  fmt.Errorf("expected CheckOut, but got %s MessageType", cmd.MessageType),
+		}
 	}
-	return svc.archiveAndPublish(CheckoutTopic, cmd)
+	return svc.archiveAndPublish(ctx, CheckoutTopic, cmd)
 }
 
-func (svc *CheckinService) archiveAndPublish(topic string, cmd mdm.CheckinCommand) error {
-	event := checkin.NewEvent(cmd)
-	msg, err := checkin.MarshalEvent(event)
+func (svc *CheckinService) archiveAndPublish(ctx context.Context, topic string, cmd mdm.CheckinCommand) error {
+	if svc.topic != "" {
+		topic = svc.topic
+	}
+	topics := []string{topic}
+	if svc.router != nil {
+		var err error
+		if topics, err = svc.router.Route(ctx, cmd, topic); err != nil {
+			return err
+		}
+	}
+	event, msg, err := svc.archiveEvent(ctx, cmd)
 	if err != nil {
-		return err
+		return &checkin.Error{Kind: checkin.KindStorageUnavailable, Err: err}
 	}
-	if err := svc.archiveFn(event.Time.UnixNano(), msg); err != nil {
-		return err
+	for _, topic := range topics {
+		span := startSpan(ctx, "publish")
+		span.SetTag("topic", topic)
+		begin := time.Now()
+		err := svc.publish(ctx, topic, cmd.UDID, msg)
+		finishSpan(span, err)
+		if err != nil {
+			return &checkin.Error{Kind: checkin.KindUpstreamUnavailable, Err: err}
+		}
+		svc.metrics.PublishDuration.With("topic", topic).Observe(time.Since(begin).Seconds())
 	}
-	if err := svc.Publish(topic, msg); err != nil {
-		return err
+	for _, fn := range svc.observers {
+		fn(event)
 	}
 	return nil
 }
 
+// publish publishes an archived event, retrying within ctx if WithRetry
+// is set.
+func (svc *CheckinService) publish(ctx context.Context, topic, key string, msg []byte) error {
+	if svc.retry != nil {
+		return svc.retry.publishContext(ctx, topic, key, msg)
+	}
+	return publish(svc.publisher, topic, key, msg)
+}
+
+// archiveEvent creates and archives the event of a check-in. The events of
+// a device are created and archived one at a time, so that their archive
+// times and sequence numbers increase together. Publishing, which may be
+// retried for a while, happens after the device is unlocked; consumers
+// order the events of a device by their Sequence.
+func (svc *CheckinService) archiveEvent(ctx context.Context, cmd mdm.CheckinCommand) (*checkin.Event, []byte, error) {
+	unlock := svc.locks.lock(cmd.UDID)
+	defer unlock()
+
+	event := checkin.NewEventWithContext(ctx, cmd)
+	span := startSpan(ctx, "archive")
+	begin := time.Now()
+	msg, err := svc.archiveFn(event.Time.UnixNano(), event)
+	finishSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	svc.metrics.ArchiveDuration.Observe(time.Since(begin).Seconds())
+	return event, msg, nil
+}
+
+// startSpan starts a child span of the span in ctx. If ctx has no span, the
+// returned span is a no-op.
+func startSpan(ctx context.Context, operationName string) opentracing.Span {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return opentracing.NoopTracer{}.StartSpan(operationName)
+	}
+	return parent.Tracer().StartSpan(operationName, opentracing.ChildOf(parent.Context()))
+}
+
+// finishSpan marks the span as failed if err is not nil and finishes it.
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+	}
+	span.Finish()
+}
+
 // archive events to BoltDB bucket using timestamp as key to preserve order.
-func (svc *CheckinService) archive(nano int64, msg []byte) error {
+func (svc *CheckinService) archive(nano int64, event *checkin.Event) ([]byte, error) {
 	tx, err := svc.db.Begin(true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	bkt := tx.Bucket([]byte(CheckinBucket))
-	if bkt == nil {
-		return fmt.Errorf("bucket %q not found!", CheckinBucket)
+	msg, err := svc.putEvent(tx, nano, event)
+	if err != nil {
+		return nil, err
 	}
-	key := []byte(fmt.Sprintf("%d", nano))
-	if err := bkt.Put(key, msg); err != nil {
+	return msg, tx.Commit()
+}
+
+// batchArchive archives events like archive, but shares the write
+// transaction with concurrent callers. It returns once the transaction
+// holding the event has been committed.
+func (svc *CheckinService) batchArchive(nano int64, event *checkin.Event) ([]byte, error) {
+	var msg []byte
+	err := svc.batch.update(func(tx *bolt.Tx) error {
+		var err error
+		msg, err = svc.putEvent(tx, nano, event)
 		return err
+	})
+	return msg, err
+}
+
+// putEvent assigns the event's sequence number, updates the state of its
+// device and archives and indexes it.
+func (svc *CheckinService) putEvent(tx *bolt.Tx, nano int64, event *checkin.Event) ([]byte, error) {
+	bkt := tx.Bucket([]byte(svc.bucket))
+	if bkt == nil {
+		return nil, fmt.Errorf("bucket %q not found!", svc.bucket)
+	}
+	// Bolt keys can't be empty, so events without a UDID are not sequenced.
+	if event.Command.UDID != "" {
+		seq, err := nextSequence(tx, svc.sequenceBucket, event.Command.UDID)
+		if err != nil {
+			return nil, err
+		}
+		event.Sequence = seq
+	}
+	// Concurrent check-ins can share a timestamp. Move the event to the
+	// next free nanosecond instead of overwriting an archived event. The
+	// event's Time is set to its key, so that EventKey returns the key.
+	key := archiveKey(nano)
+	for bkt.Get(key) != nil {
+		nano++
+		key = archiveKey(nano)
+	}
+	event.Time = time.Unix(0, nano).UTC()
+	if err := updateDevice(tx, svc.deviceBucket, event); err != nil {
+		return nil, err
+	}
+	if err := indexEvent(tx, svc.bucket, key, event); err != nil {
+		return nil, err
+	}
+	msg, err := checkin.MarshalEvent(event)
+	if err != nil {
+		return nil, err
 	}
-	return tx.Commit()
+	return msg, bkt.Put(key, msg)
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/groob/plist"
@@ -26,6 +27,7 @@ func TestService_Authenticate(t *testing.T) {
 		request   mdm.CheckinCommand
 		timestamp int64
 		wantErr   bool
+		wantKind  checkin.Kind
 	}{
 		{
 			name:      "happy_path",
@@ -40,6 +42,7 @@ func TestService_Authenticate(t *testing.T) {
 			request:   mustLoadCommand(t, "Authenticate"),
 			archiveFn: archiveFail(),
 			wantErr:   true,
+			wantKind:  checkin.KindStorageUnavailable,
 		},
 		{
 			name:      "publisher_fail",
@@ -47,6 +50,7 @@ func TestService_Authenticate(t *testing.T) {
 			request:   mustLoadCommand(t, "Authenticate"),
 			archiveFn: svc.archive,
 			wantErr:   true,
+			wantKind:  checkin.KindUpstreamUnavailable,
 		},
 		{
 			name:      "messageType_fail",
@@ -54,6 +58,7 @@ func TestService_Authenticate(t *testing.T) {
 			request:   mustLoadCommand(t, "CheckOut"),
 			archiveFn: svc.archive,
 			wantErr:   true,
+			wantKind:  checkin.KindValidation,
 		},
 	}
 	for _, tt := range tests {
@@ -68,6 +73,9 @@ func TestService_Authenticate(t *testing.T) {
 				return
 			}
 			if tt.wantErr {
+				if kind, ok := checkin.ErrorKind(err); !ok || kind != tt.wantKind {
+					t.Errorf("%q. want error kind %s, have %v", tt.name, tt.wantKind, err)
+				}
 				return
 			}
 
@@ -242,14 +250,14 @@ func loadEvent(t *testing.T, db *bolt.DB, nano int64) *checkin.Event {
 
 // override the timestamp with a custom value when saving to BoltDB.
 func archiveAt(timestamp int64, svc *CheckinService) archiveFunc {
-	return func(nano int64, event []byte) error {
+	return func(nano int64, event *checkin.Event) ([]byte, error) {
 		return svc.archive(timestamp, event)
 	}
 }
 
 func archiveFail() archiveFunc {
-	return func(nano int64, event []byte) error {
-		return errors.New("archive failed")
+	return func(nano int64, event *checkin.Event) ([]byte, error) {
+		return nil, errors.New("archive failed")
 	}
 }
 
@@ -268,7 +276,7 @@ var failPublisher = func(string, []byte) error {
 	return errors.New("failed")
 }
 
-func setupDB(t *testing.T) *CheckinService {
+func setupDB(t *testing.T, opts ...Option) *CheckinService {
 	f, _ := ioutil.TempFile("", "bolt-")
 	f.Close()
 	os.Remove(f.Name())
@@ -277,7 +285,7 @@ func setupDB(t *testing.T) *CheckinService {
 	if err != nil {
 		t.Fatalf("couldn't open bolt, err %s\n", err)
 	}
-	svc, err := NewService(db, nil)
+	svc, err := NewService(db, nil, opts...)
 	if err != nil {
 		t.Fatalf("couldn't create service, err %s\n", err)
 	}
@@ -295,3 +303,40 @@ func mustLoadCommand(t *testing.T, name string) mdm.CheckinCommand {
 	}
 	return payload
 }
+
+func BenchmarkArchive(b *testing.B) {
+	f, _ := ioutil.TempFile("", "bolt-")
+	f.Close()
+	defer os.Remove(f.Name())
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		b.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	defer db.Close()
+	svc, err := NewService(db, nil)
+	if err != nil {
+		b.Fatalf("couldn't create service, err %s\n", err)
+	}
+
+	cmd := mdm.CheckinCommand{
+		MessageType: "TokenUpdate",
+		UDID:        "some-device",
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := svc.archive(time.Now().UnixNano(), checkin.NewEvent(cmd)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := svc.archive(time.Now().UnixNano(), checkin.NewEvent(cmd)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package simple
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/checkin"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	var msg []byte
+	pub := &mockPublisher{PublishFn: func(topic string, b []byte) error {
+		msg = b
+		return nil
+	}}
+	svc := setupDB(t, WithPublisher(pub))
+
+	parent := tracer.StartSpan("checkin")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	if err := svc.TokenUpdate(ctx, mustLoadCommand(t, "TokenUpdate")); err != nil {
+		t.Fatal(err)
+	}
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 3 {
+		t.Fatalf("want 3 finished spans, have %d", len(spans))
+	}
+	for i, name := range []string{"archive", "publish"} {
+		if spans[i].OperationName != name {
+			t.Errorf("want span %q, have %q", name, spans[i].OperationName)
+		}
+		if spans[i].ParentID != parent.Context().(mocktracer.MockSpanContext).SpanID {
+			t.Errorf("span %q is not a child of the check-in span", name)
+		}
+	}
+	if have := spans[1].Tag("topic"); have != TokenUpdateTopic {
+		t.Errorf("want topic tag %q, have %v", TokenUpdateTopic, have)
+	}
+
+	var event checkin.Event
+	if err := checkin.UnmarshalEvent(msg, &event); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := checkin.ExtractTrace(tracer, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.(mocktracer.MockSpanContext).SpanID != parent.Context().(mocktracer.MockSpanContext).SpanID {
+		t.Error("published event does not carry the check-in trace context")
+	}
+}
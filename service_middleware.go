@@ -0,0 +1,217 @@
+package checkin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+// Redaction is how LoggingMiddleware logs a sensitive field.
+type Redaction int
+
+const (
+	// Omit does not log the field.
+	Omit Redaction = iota
+
+	// Fingerprint logs the first bytes of the SHA-256 hash of the field,
+	// which tells values apart without revealing them.
+	Fingerprint
+
+	// Plain logs the field base64 encoded.
+	Plain
+)
+
+// LoggingConfig configures LoggingMiddleware.
+type LoggingConfig struct {
+	// Redact sets how the sensitive fields "Token", "UnlockToken" and
+	// "Challenge" are logged. Fields which are not in the map are
+	// omitted.
+	Redact map[string]Redaction
+
+	// SampleSuccess logs one in every SampleSuccess successful check-ins.
+	// Failed check-ins are always logged. Zero logs every check-in.
+	SampleSuccess int
+
+	// TrackedTokens is the number of devices and users whose last push
+	// token is kept in memory, to log if a TokenUpdate changed it.
+	// Defaults to 10000.
+	TrackedTokens int
+}
+
+// LoggingMiddleware returns a service middleware which logs every check-in
+// with its method, device, outcome and duration.
+func LoggingMiddleware(logger log.Logger, config LoggingConfig) Middleware {
+	if config.TrackedTokens <= 0 {
+		config.TrackedTokens = 10000
+	}
+	tokens, err := lru.New(config.TrackedTokens)
+	if err != nil {
+		panic(err) // only fails for a non-positive size
+	}
+	return func(next Service) Service {
+		return &loggingService{
+			next:   next,
+			logger: logger,
+			config: config,
+			tokens: tokens,
+		}
+	}
+}
+
+type loggingService struct {
+	next   Service
+	logger log.Logger
+	config LoggingConfig
+
+	successes uint64
+
+	mu     sync.Mutex
+	tokens *lru.Cache
+}
+
+func (svc *loggingService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer func(begin time.Time) {
+		svc.log("Authenticate", begin, err, cmd,
+			"serial_number", cmd.SerialNumber,
+			"product_name", cmd.ProductName,
+			"os_version", cmd.OSVersion,
+		)
+	}(time.Now())
+	return svc.next.Authenticate(ctx, cmd)
+}
+
+func (svc *loggingService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer func(begin time.Time) {
+		var changed bool
+		if err == nil {
+			changed = svc.tokenChanged(cmd)
+		}
+		svc.log("TokenUpdate", begin, err, cmd, "token_changed", changed)
+	}(time.Now())
+	return svc.next.TokenUpdate(ctx, cmd)
+}
+
+func (svc *loggingService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer func(begin time.Time) {
+		svc.log("CheckOut", begin, err, cmd)
+	}(time.Now())
+	return svc.next.CheckOut(ctx, cmd)
+}
+
+// log logs a check-in handled by the named method. The MessageType of the
+// command is logged too if it is not the method's, as the next service
+// rejects such a check-in.
+func (svc *loggingService) log(method string, begin time.Time, err error, cmd mdm.CheckinCommand, keyvals ...interface{}) {
+	if err == nil && svc.config.SampleSuccess > 1 {
+		n := atomic.AddUint64(&svc.successes, 1)
+		if n%uint64(svc.config.SampleSuccess) != 1 {
+			return
+		}
+	}
+	kv := []interface{}{
+		"method", method,
+		"udid", cmd.UDID,
+		"topic", cmd.Topic,
+	}
+	if cmd.MessageType != method {
+		kv = append(kv, "message_type", cmd.MessageType)
+	}
+	if cmd.UserID != "" {
+		kv = append(kv, "user_id", cmd.UserID)
+	}
+	kv = append(kv, keyvals...)
+	kv = svc.appendSensitive(kv, "Token", "token", cmd.Token)
+	kv = svc.appendSensitive(kv, "UnlockToken", "unlock_token", cmd.UnlockToken)
+	kv = svc.appendSensitive(kv, "Challenge", "challenge", cmd.Challenge)
+	kv = append(kv, "err", err, "took", time.Since(begin))
+	svc.logger.Log(kv...)
+}
+
+func (svc *loggingService) appendSensitive(kv []interface{}, field, key string, value []byte) []interface{} {
+	if len(value) == 0 {
+		return kv
+	}
+	switch svc.config.Redact[field] {
+	case Fingerprint:
+		sum := sha256.Sum256(value)
+		return append(kv, key, hex.EncodeToString(sum[:6]))
+	case Plain:
+		return append(kv, key, base64.StdEncoding.EncodeToString(value))
+	default:
+		return kv
+	}
+}
+
+// tokenChanged records the push token of a TokenUpdate and reports whether
+// it differs from the last token seen for the device or user. The first
+// token seen is reported as changed.
+func (svc *loggingService) tokenChanged(cmd mdm.CheckinCommand) bool {
+	key := cmd.UDID + "/" + cmd.UserID
+	sum := sha256.Sum256(cmd.Token)
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	last, ok := svc.tokens.Get(key)
+	svc.tokens.Add(key, sum)
+	return !ok || last.([sha256.Size]byte) != sum
+}
+
+// InstrumentingMiddleware returns a service middleware which counts
+// check-ins and observes their duration, in seconds. Both metrics are
+// labeled by "message_type" and "error_class". The error class is "none"
+// for successful check-ins, "canceled" if the request was canceled, the
+// Kind of an *Error, and "error" otherwise.
+func InstrumentingMiddleware(requests metrics.Counter, duration metrics.Histogram) Middleware {
+	return func(next Service) Service {
+		return &instrumentingService{next: next, requests: requests, duration: duration}
+	}
+}
+
+type instrumentingService struct {
+	next     Service
+	requests metrics.Counter
+	duration metrics.Histogram
+}
+
+func (svc *instrumentingService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer svc.observe(ctx, "Authenticate", time.Now(), &err)
+	return svc.next.Authenticate(ctx, cmd)
+}
+
+func (svc *instrumentingService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer svc.observe(ctx, "TokenUpdate", time.Now(), &err)
+	return svc.next.TokenUpdate(ctx, cmd)
+}
+
+func (svc *instrumentingService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) (err error) {
+	defer svc.observe(ctx, "CheckOut", time.Now(), &err)
+	return svc.next.CheckOut(ctx, cmd)
+}
+
+func (svc *instrumentingService) observe(ctx context.Context, messageType string, begin time.Time, err *error) {
+	lvs := []string{"message_type", messageType, "error_class", errorClass(ctx, *err)}
+	svc.requests.With(lvs...).Add(1)
+	svc.duration.With(lvs...).Observe(time.Since(begin).Seconds())
+}
+
+// errorClass returns the metric label for the outcome of a check-in.
+func errorClass(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return "none"
+	case ctx.Err() != nil:
+		return "canceled"
+	}
+	if kind, ok := ErrorKind(err); ok {
+		return kind.String()
+	}
+	return "error"
+}
@@ -0,0 +1,84 @@
+package checkin
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	next := &mock.CheckinService{
+		AuthenticateFunc: mock.FailCheckin,
+		TokenUpdateFunc:  mock.SucceedCheckin,
+	}
+	svc := LoggingMiddleware(log.NewLogfmtLogger(&buf), LoggingConfig{
+		Redact: map[string]Redaction{
+			"Token":     Fingerprint,
+			"Challenge": Plain,
+		},
+	})(next)
+	ctx := context.Background()
+
+	auth := mdm.CheckinCommand{MessageType: "Authenticate", UDID: "some-device"}
+	auth.SerialNumber = "C02RX6G8G8WP"
+	auth.Challenge = []byte("apple")
+	svc.Authenticate(ctx, auth)
+
+	update := mdm.CheckinCommand{MessageType: "TokenUpdate", UDID: "some-device"}
+	update.Token = []byte("token")
+	update.UnlockToken = []byte("unlock")
+	svc.TokenUpdate(ctx, update)
+	svc.TokenUpdate(ctx, update)
+	update.Token = []byte("new-token")
+	svc.TokenUpdate(ctx, update)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want 4 log lines, have %d:\n%s", len(lines), buf.String())
+	}
+	wants := [][]string{
+		{"method=Authenticate", "udid=some-device", "serial_number=C02RX6G8G8WP", `challenge="YXBwbGU="`, `err="checkin failed"`},
+		{"method=TokenUpdate", "token_changed=true", "token="},
+		{"method=TokenUpdate", "token_changed=false"},
+		{"method=TokenUpdate", "token_changed=true"},
+	}
+	for i, want := range wants {
+		for _, kv := range want {
+			if !strings.Contains(lines[i], kv) {
+				t.Errorf("line %d: want %s in %q", i, kv, lines[i])
+			}
+		}
+		if strings.Contains(lines[i], "unlock_token") {
+			t.Errorf("line %d: unlock token not redacted: %q", i, lines[i])
+		}
+	}
+}
+
+func TestLoggingMiddleware_sample(t *testing.T) {
+	var buf bytes.Buffer
+	next := &mock.CheckinService{
+		CheckoutFunc:    mock.SucceedCheckin,
+		TokenUpdateFunc: mock.FailCheckin,
+	}
+	svc := LoggingMiddleware(log.NewLogfmtLogger(&buf), LoggingConfig{SampleSuccess: 10})(next)
+	cmd := mdm.CheckinCommand{MessageType: "CheckOut", UDID: "some-device"}
+	for i := 0; i < 20; i++ {
+		svc.CheckOut(context.Background(), cmd)
+	}
+	svc.TokenUpdate(context.Background(), cmd)
+	if want, have := 3, strings.Count(buf.String(), "\n"); want != have {
+		t.Errorf("want %d log lines, have %d", want, have)
+	}
+	// the TokenUpdate is logged by method, not by its MessageType.
+	for _, want := range []string{"method=TokenUpdate", "message_type=CheckOut"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %s in %q", want, buf.String())
+		}
+	}
+}
@@ -40,7 +40,7 @@ func TestHTTPCheckin_Authenticate(t *testing.T) {
 			name:         "limit_reader",
 			method:       mock.SucceedCheckin,
 			request:      neverEnding('a'),
-			expectStatus: http.StatusUnauthorized,
+			expectStatus: http.StatusRequestEntityTooLarge,
 		},
 	}
 
@@ -85,7 +85,7 @@ func TestHTTPCheckin_TokenUpdate(t *testing.T) {
 			name:         "limit_reader",
 			method:       mock.SucceedCheckin,
 			request:      neverEnding('a'),
-			expectStatus: http.StatusUnauthorized,
+			expectStatus: http.StatusRequestEntityTooLarge,
 		},
 	}
 
@@ -130,13 +130,13 @@ func TestHTTPCheckin_CheckOut(t *testing.T) {
 			name:         "invalid_messageType",
 			method:       mock.FailCheckin,
 			request:      mustMarshalCheckinRequest(t, "UnknownMessageType"),
-			expectStatus: http.StatusUnauthorized,
+			expectStatus: http.StatusNotFound,
 		},
 		{
 			name:         "limit_reader",
 			method:       mock.SucceedCheckin,
 			request:      neverEnding('a'),
-			expectStatus: http.StatusUnauthorized,
+			expectStatus: http.StatusRequestEntityTooLarge,
 		},
 	}
 
@@ -0,0 +1,170 @@
+package simulator
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/micromdm/mdm"
+)
+
+// Device is a simulated MDM client.
+type Device struct {
+	UDID         string
+	SerialNumber string
+	IMEI         string
+	MEID         string
+	DeviceName   string
+	Topic        string
+
+	Model        string
+	ModelName    string
+	ProductName  string
+	OSVersion    string
+	BuildVersion string
+
+	Token       []byte
+	PushMagic   string
+	UnlockToken []byte
+
+	// The user channel is only used if UserID is set.
+	UserID        string
+	UserShortName string
+	UserLongName  string
+	UserToken     []byte
+
+	// Certificate and PrivateKey are the device identity used to sign
+	// requests. Requests are not signed if Certificate is nil.
+	Certificate *x509.Certificate
+	PrivateKey  *rsa.PrivateKey
+}
+
+type hardware struct {
+	productName  string
+	model        string
+	modelName    string
+	osVersion    string
+	buildVersion string
+	cellular     bool
+}
+
+var fleet = []hardware{
+	{"iPhone9,1", "MN8G2LL/A", "iPhone", "10.1.1", "14B100", true},
+	{"iPhone8,1", "MKQJ2LL/A", "iPhone", "10.1.1", "14B100", true},
+	{"iPad6,3", "MLMN2LL/A", "iPad", "10.1.1", "14B100", false},
+	{"iPad5,3", "MGL12LL/A", "iPad", "9.3.5", "13G36", false},
+	{"iPod7,1", "MKH02LL/A", "iPod touch", "10.1.1", "14B100", false},
+	{"MacBookPro13,1", "MLL42LL/A", "MacBook Pro", "10.12.1", "16B2657", false},
+	{"iMac17,1", "MK462LL/A", "iMac", "10.12.1", "16B2657", false},
+}
+
+// serial numbers use uppercase letters and digits, excluding vowels.
+const serialAlphabet = "0123456789BCDFGHJKLMNPQRSTVWXYZ"
+
+// NewDevice returns a device with random, but well formed identifiers.
+func NewDevice(r *rand.Rand, topic string) *Device {
+	hw := fleet[r.Intn(len(fleet))]
+	serial := randomString(r, serialAlphabet, 12)
+	d := &Device{
+		UDID:         randomUUID(r),
+		SerialNumber: serial,
+		DeviceName:   fmt.Sprintf("%s %s", hw.modelName, serial[8:]),
+		Topic:        topic,
+		Model:        hw.model,
+		ModelName:    hw.modelName,
+		ProductName:  hw.productName,
+		OSVersion:    hw.osVersion,
+		BuildVersion: hw.buildVersion,
+		Token:        randomBytes(r, 32),
+		PushMagic:    randomUUID(r),
+		UnlockToken:  randomBytes(r, 64),
+	}
+	if hw.cellular {
+		d.IMEI = randomString(r, "0123456789", 15)
+		d.MEID = d.IMEI[:14]
+	}
+	return d
+}
+
+// AddUser adds a random user to the device, enabling the user channel.
+func (d *Device) AddUser(r *rand.Rand) {
+	short := randomString(r, "abcdefghijklmnopqrstuvwxyz", 8)
+	d.UserID = randomUUID(r)
+	d.UserShortName = short
+	d.UserLongName = strings.Title(short) + " Simulated"
+	d.UserToken = randomBytes(r, 32)
+}
+
+// Authenticate returns the Authenticate message sent by the device.
+func (d *Device) Authenticate() mdm.CheckinCommand {
+	cmd := d.command("Authenticate")
+	cmd.OSVersion = d.OSVersion
+	cmd.BuildVersion = d.BuildVersion
+	cmd.ProductName = d.ProductName
+	cmd.SerialNumber = d.SerialNumber
+	cmd.IMEI = d.IMEI
+	cmd.MEID = d.MEID
+	cmd.DeviceName = d.DeviceName
+	cmd.Model = d.Model
+	cmd.ModelName = d.ModelName
+	return cmd
+}
+
+// TokenUpdate returns the device channel TokenUpdate message.
+func (d *Device) TokenUpdate() mdm.CheckinCommand {
+	cmd := d.command("TokenUpdate")
+	cmd.Token = d.Token
+	cmd.PushMagic = d.PushMagic
+	cmd.UnlockToken = d.UnlockToken
+	return cmd
+}
+
+// UserTokenUpdate returns the user channel TokenUpdate message.
+func (d *Device) UserTokenUpdate() mdm.CheckinCommand {
+	cmd := d.command("TokenUpdate")
+	cmd.Token = d.UserToken
+	cmd.PushMagic = d.PushMagic
+	cmd.UserID = d.UserID
+	cmd.UserShortName = d.UserShortName
+	cmd.UserLongName = d.UserLongName
+	return cmd
+}
+
+// CheckOut returns the CheckOut message sent when the device unenrolls.
+func (d *Device) CheckOut() mdm.CheckinCommand {
+	return d.command("CheckOut")
+}
+
+func (d *Device) command(messageType string) mdm.CheckinCommand {
+	return mdm.CheckinCommand{
+		MessageType: messageType,
+		Topic:       d.Topic,
+		UDID:        d.UDID,
+	}
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(r.Intn(256))
+	}
+	return b
+}
+
+func randomString(r *rand.Rand, alphabet string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+// randomUUID returns an uppercase version 4 UUID, as used by Apple devices.
+func randomUUID(r *rand.Rand) string {
+	b := randomBytes(r, 16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,271 @@
+// Package simulator generates MDM Check-in traffic from a fleet of
+// simulated devices.
+//
+// Each simulated device enrolls with an Authenticate and a TokenUpdate,
+// optionally sends a user channel TokenUpdate, and unenrolls with a CheckOut.
+// Requests are sent to a Check-in URL, or directly to an http.Handler
+// such as checkin.HTTPHandlers.CheckinHandler.
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+
+	"github.com/micromdm/checkin/internal/mdmsig"
+)
+
+// CheckinContentType is the Content-Type sent by devices with Check-in requests.
+const CheckinContentType = "application/x-apple-aspen-mdm-checkin"
+
+// Config configures a simulated fleet.
+type Config struct {
+	// Devices is the number of devices to simulate.
+	Devices int
+
+	// Concurrency is the number of devices checking in at the same time.
+	// Defaults to 1.
+	Concurrency int
+
+	// Topic is the APNs push topic the devices enroll with.
+	// A random MDM topic is used if empty.
+	Topic string
+
+	// UserChannel makes every device send a user channel TokenUpdate.
+	UserChannel bool
+
+	// Sign makes every device generate an identity certificate and sign
+	// its requests with it.
+	Sign bool
+
+	// Seed seeds the random device generator, making fleets reproducible.
+	Seed int64
+}
+
+// Failure describes a Check-in request which did not succeed.
+type Failure struct {
+	UDID        string
+	MessageType string
+	StatusCode  int
+	Err         error
+}
+
+func (f Failure) String() string {
+	if f.Err != nil {
+		return fmt.Sprintf("%s %s: %s", f.UDID, f.MessageType, f.Err)
+	}
+	return fmt.Sprintf("%s %s: unexpected status %d", f.UDID, f.MessageType, f.StatusCode)
+}
+
+// Report summarizes a simulator run.
+type Report struct {
+	Devices  int
+	Requests int
+	Failures []Failure
+	Duration time.Duration
+
+	// Latencies holds the round trip time of every request, in the
+	// order the requests completed.
+	Latencies []time.Duration
+}
+
+// Percentile returns the request latency at percentile p, between 0 and 100.
+func (r Report) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.Latencies))
+	copy(sorted, r.Latencies)
+	sort.Sort(durations(sorted))
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Throughput returns the number of requests completed per second.
+func (r Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// Simulator sends Check-in requests for a fleet of simulated devices.
+type Simulator struct {
+	url    string
+	client *http.Client
+	config Config
+}
+
+// New creates a Simulator which sends requests to url with client.
+// http.DefaultClient is used if client is nil.
+func New(url string, client *http.Client, config Config) *Simulator {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	return &Simulator{url: url, client: client, config: config}
+}
+
+// NewForHandler creates a Simulator which serves requests with h in the
+// current process, without a network listener.
+func NewForHandler(h http.Handler, config Config) *Simulator {
+	client := &http.Client{Transport: handlerTransport{h}}
+	return New("http://simulator/checkin", client, config)
+}
+
+// Run simulates the configured fleet and reports any failed requests.
+// A device stops checking in after the first failed request.
+func (s *Simulator) Run(ctx context.Context) Report {
+	r := rand.New(rand.NewSource(s.config.Seed))
+	topic := s.config.Topic
+	if topic == "" {
+		topic = "com.apple.mgmt.XServer." + randomUUID(r)
+	}
+
+	// devices are generated up front, because rand.Rand is not safe for
+	// concurrent use.
+	devices := make(chan *Device, s.config.Devices)
+	for i := 0; i < s.config.Devices; i++ {
+		d := NewDevice(r, topic)
+		if s.config.UserChannel {
+			d.AddUser(r)
+		}
+		devices <- d
+	}
+	close(devices)
+
+	var (
+		mu     sync.Mutex
+		report = Report{Devices: s.config.Devices}
+		wg     sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < s.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range devices {
+				latencies, failure := s.simulate(ctx, d)
+				mu.Lock()
+				report.Requests += len(latencies)
+				report.Latencies = append(report.Latencies, latencies...)
+				if failure != nil {
+					report.Failures = append(report.Failures, *failure)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	return report
+}
+
+// simulate sends every Check-in message for a device, returning the latency
+// of each request sent and the first failure.
+func (s *Simulator) simulate(ctx context.Context, d *Device) ([]time.Duration, *Failure) {
+	if s.config.Sign {
+		cert, key, err := mdmsig.NewIdentity(d.UDID)
+		if err != nil {
+			return nil, &Failure{UDID: d.UDID, Err: fmt.Errorf("generate identity: %s", err)}
+		}
+		d.Certificate, d.PrivateKey = cert, key
+	}
+
+	messages := []mdm.CheckinCommand{d.Authenticate(), d.TokenUpdate()}
+	if d.UserID != "" {
+		messages = append(messages, d.UserTokenUpdate())
+	}
+	messages = append(messages, d.CheckOut())
+
+	latencies := make([]time.Duration, 0, len(messages))
+	for _, cmd := range messages {
+		select {
+		case <-ctx.Done():
+			return latencies, &Failure{UDID: d.UDID, MessageType: cmd.MessageType, Err: ctx.Err()}
+		default:
+		}
+		start := time.Now()
+		failure := s.Checkin(d, cmd)
+		latencies = append(latencies, time.Since(start))
+		if failure != nil {
+			return latencies, failure
+		}
+	}
+	return latencies, nil
+}
+
+// Checkin sends a single Check-in message on behalf of d.
+func (s *Simulator) Checkin(d *Device, cmd mdm.CheckinCommand) *Failure {
+	fail := func(code int, err error) *Failure {
+		return &Failure{UDID: d.UDID, MessageType: cmd.MessageType, StatusCode: code, Err: err}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := plist.NewEncoder(buf).Encode(&cmd); err != nil {
+		return fail(0, fmt.Errorf("encode plist: %s", err))
+	}
+	body := buf.Bytes()
+
+	req, err := http.NewRequest("PUT", s.url, bytes.NewReader(body))
+	if err != nil {
+		return fail(0, err)
+	}
+	req.Header.Set("Content-Type", CheckinContentType)
+	if d.Certificate != nil {
+		if err := mdmsig.SignRequest(req, body, d.Certificate, d.PrivateKey); err != nil {
+			return fail(0, fmt.Errorf("sign request: %s", err))
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fail(0, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fail(resp.StatusCode, nil)
+	}
+	return nil
+}
+
+// handlerTransport is an http.RoundTripper which serves requests with an
+// http.Handler.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
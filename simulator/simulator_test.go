@@ -0,0 +1,166 @@
+package simulator
+
+import (
+	"encoding/base64"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/internal/mdmsig"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestSimulator_Run(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	count := func(ctx context.Context, cmd mdm.CheckinCommand) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if cmd.UserID != "" {
+			counts["UserTokenUpdate"]++
+		} else {
+			counts[cmd.MessageType]++
+		}
+		return nil
+	}
+	// mock.CheckinService records invocations without synchronization, so
+	// it can't serve concurrent check-ins.
+	svc := funcService(count)
+
+	sim := NewForHandler(newHandler(svc), Config{
+		Devices:     20,
+		Concurrency: 4,
+		UserChannel: true,
+	})
+	report := sim.Run(context.Background())
+
+	if len(report.Failures) != 0 {
+		t.Fatalf("unexpected failures: %v", report.Failures)
+	}
+	if want, have := 80, report.Requests; want != have {
+		t.Errorf("want %d requests, have %d", want, have)
+	}
+	for _, messageType := range []string{"Authenticate", "TokenUpdate", "UserTokenUpdate", "CheckOut"} {
+		if want, have := 20, counts[messageType]; want != have {
+			t.Errorf("%s: want %d, have %d", messageType, want, have)
+		}
+	}
+}
+
+// funcService is a checkin.Service which handles every check-in with the
+// same function.
+type funcService func(ctx context.Context, cmd mdm.CheckinCommand) error
+
+func (fn funcService) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return fn(ctx, cmd)
+}
+
+func (fn funcService) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return fn(ctx, cmd)
+}
+
+func (fn funcService) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return fn(ctx, cmd)
+}
+
+func TestSimulator_Failures(t *testing.T) {
+	svc := &mock.CheckinService{
+		AuthenticateFunc: mock.SucceedCheckin,
+		TokenUpdateFunc:  mock.FailCheckin,
+		CheckoutFunc:     mock.SucceedCheckin,
+	}
+	report := NewForHandler(newHandler(svc), Config{Devices: 3}).Run(context.Background())
+
+	if want, have := 3, len(report.Failures); want != have {
+		t.Fatalf("want %d failures, have %d", want, have)
+	}
+	for _, f := range report.Failures {
+		if f.MessageType != "TokenUpdate" || f.StatusCode != http.StatusUnauthorized {
+			t.Errorf("unexpected failure %s", f)
+		}
+	}
+	if want, have := 6, report.Requests; want != have {
+		t.Errorf("want %d requests, have %d", want, have)
+	}
+}
+
+func TestSimulator_Sign(t *testing.T) {
+	var signatures []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get(mdmsig.Header))
+	})
+	report := NewForHandler(h, Config{Devices: 1, Sign: true}).Run(context.Background())
+	if len(report.Failures) != 0 {
+		t.Fatalf("unexpected failures: %v", report.Failures)
+	}
+	if want, have := 3, len(signatures); want != have {
+		t.Fatalf("want %d signed requests, have %d", want, have)
+	}
+	for _, sig := range signatures {
+		der, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := pkcs7.Parse(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p7.Certificates) != 1 {
+			t.Errorf("want signing certificate in signature")
+		}
+	}
+}
+
+func TestNewDevice_Deterministic(t *testing.T) {
+	a := NewDevice(rand.New(rand.NewSource(42)), "topic")
+	b := NewDevice(rand.New(rand.NewSource(42)), "topic")
+	if a.UDID != b.UDID || a.SerialNumber != b.SerialNumber {
+		t.Errorf("devices generated from the same seed differ: %s/%s, %s/%s",
+			a.UDID, a.SerialNumber, b.UDID, b.SerialNumber)
+	}
+	if len(a.SerialNumber) != 12 {
+		t.Errorf("want 12 character serial number, have %q", a.SerialNumber)
+	}
+}
+
+func newHandler(svc checkin.Service) http.Handler {
+	e := checkin.Endpoints{
+		CheckinEndpoint: checkin.MakeCheckinEndpoint(svc),
+	}
+	h := checkin.MakeHTTPHandlers(
+		context.Background(),
+		e,
+		httptransport.ServerErrorEncoder(checkin.EncodeError),
+	)
+	return h.CheckinHandler
+}
+
+func TestReport_Percentile(t *testing.T) {
+	var report Report
+	for i := 100; i > 0; i-- {
+		report.Latencies = append(report.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{p: 0, want: time.Millisecond},
+		{p: 50, want: 50 * time.Millisecond},
+		{p: 99, want: 99 * time.Millisecond},
+		{p: 100, want: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if have := report.Percentile(tt.p); have != tt.want {
+			t.Errorf("p%v: want %s, have %s", tt.p, tt.want, have)
+		}
+	}
+}
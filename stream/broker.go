@@ -0,0 +1,204 @@
+// Package stream pushes check-in events to HTTP clients as soon as they are
+// accepted, over Server-Sent Events or a WebSocket, so that an enrollment can
+// be followed live while debugging it.
+package stream
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/gorilla/websocket"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+)
+
+// Defaults of the Config of a Broker.
+const (
+	DefaultBuffer    = 64
+	DefaultHeartbeat = 15 * time.Second
+	DefaultMaxReplay = 1000
+)
+
+// Store is the enrollment state and archive read by a Broker to filter by
+// serial number and to replay events to resuming clients.
+// It is implemented by *simple.CheckinService.
+type Store interface {
+	ForEachDevice(fn func(*simple.Device) error) error
+	ForEachEvent(filter simple.EventFilter, fn func(*checkin.Event) error) error
+}
+
+// Config configures a Broker.
+type Config struct {
+	// Buffer is the number of events buffered for each client. Events for
+	// a client whose buffer is full are dropped, and the client is told how
+	// many it missed. Defaults to DefaultBuffer.
+	Buffer int
+
+	// Heartbeat is the interval of the keep-alive messages sent to idle
+	// clients. Defaults to DefaultHeartbeat.
+	Heartbeat time.Duration
+
+	// Store resolves the UDIDs of a serial number filter, and replays the
+	// archived events after the archive key a client resumes from. Optional; without
+	// it, a serial number filter only matches devices which authenticate
+	// after the client connected, and clients can't resume.
+	Store Store
+
+	// MaxReplay is the most events replayed to a resuming client. Older
+	// events are counted as dropped. Defaults to DefaultMaxReplay.
+	MaxReplay int
+
+	// Authorize is called with every request to ServeHTTP, which is
+	// rejected with 401 if it returns an error. Without Authorize, every
+	// request is rejected, so that the stream, which includes the serial
+	// numbers and users of all devices, is never served unauthenticated by
+	// accident.
+	Authorize func(*http.Request) error
+
+	// Logger logs failed streams. Optional.
+	Logger log.Logger
+
+	// Dropped counts the events dropped for slow clients. Optional.
+	Dropped metrics.Counter
+}
+
+// Broker fans the events accepted by a simple.CheckinService out to the
+// connected clients. Register Broker.Observe with simple.WithObserver, and
+// serve the Broker over HTTP.
+type Broker struct {
+	config   Config
+	upgrader websocket.Upgrader
+
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+// NewBroker creates a Broker.
+func NewBroker(config Config) *Broker {
+	if config.Buffer <= 0 {
+		config.Buffer = DefaultBuffer
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = DefaultHeartbeat
+	}
+	if config.MaxReplay <= 0 {
+		config.MaxReplay = DefaultMaxReplay
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Dropped == nil {
+		config.Dropped = discard.NewCounter()
+	}
+	return &Broker{
+		config: config,
+		subs:   make(map[*subscription]struct{}),
+	}
+}
+
+// Observe sends the event to every client whose filter matches it. It never
+// blocks: the event is dropped for clients which are not keeping up.
+func (b *Broker) Observe(e *checkin.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.match(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			b.config.Dropped.Add(1)
+		}
+	}
+}
+
+// Filter selects the events sent to a client. The zero value matches every
+// event.
+type Filter struct {
+	UDID         string
+	SerialNumber string
+	MessageType  string
+}
+
+// subscribe registers a client. The UDIDs of the devices with the filter's
+// serial number are looked up in the Store, if any.
+func (b *Broker) subscribe(filter Filter) (*subscription, error) {
+	sub := &subscription{
+		filter: filter,
+		udids:  make(map[string]bool),
+		events: make(chan *checkin.Event, b.config.Buffer),
+	}
+	if filter.SerialNumber != "" && b.config.Store != nil {
+		err := b.config.Store.ForEachDevice(func(d *simple.Device) error {
+			if d.SerialNumber == filter.SerialNumber {
+				sub.udids[d.UDID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub, nil
+}
+
+func (b *Broker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+}
+
+// subscription is a connected client.
+type subscription struct {
+	// dropped is the number of events dropped since the client was last
+	// told. It is accessed atomically, and so comes first for alignment.
+	dropped uint64
+
+	filter Filter
+	events chan *checkin.Event
+
+	// udids are the devices with the serial number of the filter. A device
+	// which authenticates with the serial number is added.
+	mu    sync.Mutex
+	udids map[string]bool
+}
+
+func (s *subscription) match(e *checkin.Event) bool {
+	cmd := e.Command
+	switch {
+	case s.filter.UDID != "" && cmd.UDID != s.filter.UDID:
+		return false
+	case s.filter.SerialNumber != "" && !s.hasSerialNumber(cmd.UDID, cmd.SerialNumber):
+		return false
+	case s.filter.MessageType != "" && cmd.MessageType != s.filter.MessageType:
+		return false
+	}
+	return true
+}
+
+// hasSerialNumber reports whether the device has the serial number of the
+// filter. The device is remembered if serial, which is only sent by an
+// Authenticate, matches.
+func (s *subscription) hasSerialNumber(udid, serial string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if serial == s.filter.SerialNumber {
+		s.udids[udid] = true
+	}
+	return s.udids[udid]
+}
+
+// takeDropped returns the number of events dropped since it was last called.
+func (s *subscription) takeDropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
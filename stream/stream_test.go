@@ -0,0 +1,245 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/websocket"
+	"github.com/groob/plist"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestSSE(t *testing.T) {
+	svc, b := setup(t, Config{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	ctx := context.Background()
+
+	auth := mustLoadCommand(t, "Authenticate")
+	resp, err := http.Get(srv.URL + "?serial_number=" + auth.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if want, have := "text/event-stream", resp.Header.Get("Content-Type"); want != have {
+		t.Fatalf("want Content-Type %s, have %s", want, have)
+	}
+
+	other := auth
+	other.UDID, other.SerialNumber = "other-device", "other-serial"
+	for _, cmd := range []mdm.CheckinCommand{auth, other, mustLoadCommand(t, "TokenUpdate")} {
+		if err := checkinWith(ctx, svc, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bufio.NewReader(resp.Body)
+	var ids []string
+	for _, messageType := range []string{"Authenticate", "TokenUpdate"} {
+		msg := readSSE(t, r)
+		var e Event
+		if err := json.Unmarshal([]byte(msg["data"]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.MessageType != messageType || e.UDID != auth.UDID {
+			t.Fatalf("want %s of %s, have %+v", messageType, auth.UDID, e)
+		}
+		if want := strconv.FormatInt(e.Time.UnixNano(), 10); msg["id"] != want {
+			t.Fatalf("want archive key %s as id, have %s", want, msg["id"])
+		}
+		ids = append(ids, msg["id"])
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", srv.URL+"?udid="+auth.UDID, nil)
+		req.Header.Set("Last-Event-ID", ids[0])
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if msg := readSSE(t, bufio.NewReader(resp.Body)); msg["id"] != ids[1] {
+			t.Errorf("want replayed event %s, have %v", ids[1], msg)
+		}
+	})
+
+	t.Run("invalid_event_id", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "?last_event_id=unknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("want status 400, have %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+	svc, b := setup(t, Config{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?message_type=TokenUpdate"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	for _, name := range []string{"Authenticate", "TokenUpdate"} {
+		if err := checkinWith(ctx, svc, mustLoadCommand(t, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "checkin" || msg.Event == nil || msg.Event.MessageType != "TokenUpdate" {
+		t.Fatalf("want TokenUpdate message, have %+v", msg)
+	}
+	if want := strconv.FormatInt(msg.Event.Time.UnixNano(), 10); msg.ID != want {
+		t.Errorf("want archive key %s as id, have %s", want, msg.ID)
+	}
+}
+
+func TestObserve_slowClient(t *testing.T) {
+	b := NewBroker(Config{Buffer: 1})
+	sub, err := b.subscribe(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		b.Observe(checkin.NewEvent(mdm.CheckinCommand{MessageType: "TokenUpdate"}))
+	}
+	if want, have := 1, len(sub.events); want != have {
+		t.Errorf("want %d buffered event, have %d", want, have)
+	}
+	if want, have := uint64(2), sub.takeDropped(); want != have {
+		t.Errorf("want %d dropped events, have %d", want, have)
+	}
+	b.unsubscribe(sub)
+	b.Observe(checkin.NewEvent(mdm.CheckinCommand{MessageType: "TokenUpdate"}))
+	if have := sub.takeDropped(); have != 0 {
+		t.Errorf("unsubscribed: want no dropped events, have %d", have)
+	}
+}
+
+func TestServeHTTP_unauthorized(t *testing.T) {
+	token := func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+	tests := []struct {
+		name      string
+		authorize func(*http.Request) error
+		header    string
+		want      int
+	}{
+		{name: "no_authorize", header: "Bearer secret", want: http.StatusUnauthorized},
+		{name: "invalid_token", authorize: token, header: "Bearer other", want: http.StatusUnauthorized},
+		{name: "valid_token", authorize: token, header: "Bearer secret", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(Config{Authorize: tt.authorize})
+			// a valid request fails only because of its method, so that
+			// it isn't streamed.
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			b.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("want status %d, have %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+// readSSE reads the fields of the next message of a Server-Sent Events
+// stream.
+func readSSE(t *testing.T, r *bufio.Reader) map[string]string {
+	msg := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return msg
+		}
+		if i := strings.Index(line, ": "); i > 0 {
+			msg[line[:i]] = line[i+2:]
+		}
+	}
+}
+
+func checkinWith(ctx context.Context, svc checkin.Service, cmd mdm.CheckinCommand) error {
+	switch cmd.MessageType {
+	case "Authenticate":
+		return svc.Authenticate(ctx, cmd)
+	case "TokenUpdate":
+		return svc.TokenUpdate(ctx, cmd)
+	default:
+		return svc.CheckOut(ctx, cmd)
+	}
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(topic string, body []byte) error { return nil }
+
+func setup(t *testing.T, config Config) (*simple.CheckinService, *Broker) {
+	f, _ := ioutil.TempFile("", "bolt-")
+	f.Close()
+	os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		t.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	var b *Broker
+	svc, err := simple.NewService(db, nil,
+		simple.WithPublisher(nopPublisher{}),
+		simple.WithObserver(func(e *checkin.Event) { b.Observe(e) }),
+	)
+	if err != nil {
+		t.Fatalf("couldn't create service, err %s\n", err)
+	}
+	config.Store = svc
+	if config.Authorize == nil {
+		config.Authorize = func(*http.Request) error { return nil }
+	}
+	b = NewBroker(config)
+	return svc, b
+}
+
+func mustLoadCommand(t *testing.T, name string) mdm.CheckinCommand {
+	var payload mdm.CheckinCommand
+	data, err := ioutil.ReadFile("../testdata/" + name + ".plist")
+	if err != nil {
+		t.Fatalf("failed to open test file %q.plist, err: %s", name, err)
+	}
+	if err := plist.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("failed to unmarshal plist %q, err: %s", name, err)
+	}
+	return payload
+}
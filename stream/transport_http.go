@@ -0,0 +1,346 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+)
+
+// writeWait is the time allowed to write a message to a WebSocket.
+const writeWait = 10 * time.Second
+
+var errInvalidEventID = errors.New("stream: invalid event ID")
+
+// Event is a check-in event as sent to clients. Secrets sent by the device,
+// such as the push token and the UnlockToken, are left out.
+type Event struct {
+	ID                    string    `json:"id"`
+	Time                  time.Time `json:"time"`
+	UDID                  string    `json:"udid"`
+	MessageType           string    `json:"message_type"`
+	Topic                 string    `json:"topic,omitempty"`
+	SerialNumber          string    `json:"serial_number,omitempty"`
+	ProductName           string    `json:"product_name,omitempty"`
+	Model                 string    `json:"model,omitempty"`
+	OSVersion             string    `json:"os_version,omitempty"`
+	DeviceName            string    `json:"device_name,omitempty"`
+	UserID                string    `json:"user_id,omitempty"`
+	AwaitingConfiguration bool      `json:"awaiting_configuration,omitempty"`
+	Sequence              uint64    `json:"sequence,omitempty"`
+	Duplicate             bool      `json:"duplicate,omitempty"`
+	PolicyRule            string    `json:"policy_rule,omitempty"`
+}
+
+func makeEvent(e *checkin.Event) Event {
+	cmd := e.Command
+	return Event{
+		ID:                    e.ID,
+		Time:                  e.Time,
+		UDID:                  cmd.UDID,
+		MessageType:           cmd.MessageType,
+		Topic:                 cmd.Topic,
+		SerialNumber:          cmd.SerialNumber,
+		ProductName:           cmd.ProductName,
+		Model:                 cmd.Model,
+		OSVersion:             cmd.OSVersion,
+		DeviceName:            cmd.DeviceName,
+		UserID:                cmd.UserID,
+		AwaitingConfiguration: cmd.AwaitingConfiguration,
+		Sequence:              e.Sequence,
+		Duplicate:             e.Duplicate,
+		PolicyRule:            e.PolicyRule,
+	}
+}
+
+// ServeHTTP streams events to the client until it disconnects. A WebSocket
+// upgrade request is served over a WebSocket, and any other GET request as
+// Server-Sent Events. The udid, serial_number and message_type query
+// parameters filter the events.
+//
+// Over Server-Sent Events, each check-in is a message with the archive key
+// of the event, as returned by simple.EventKey, as its id and the JSON of an
+// Event as its data. Over a WebSocket, each check-in is a JSON message
+// {"type": "checkin", "id": key, "event": Event}.
+// A client which missed events because it was not keeping up is sent a
+// "dropped" event with the data {"dropped": n}, or the WebSocket message
+// {"type": "dropped", "dropped": n}, before the next check-in.
+//
+// A client resumes after the archive key in the Last-Event-ID header or the
+// last_event_id query parameter by first being sent the matching archived
+// events which followed it. Resuming requires a Store.
+//
+// Requests are rejected with 401 unless authorized by Config.Authorize.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.config.Authorize == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := b.config.Authorize(r); err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	filter := Filter{
+		UDID:         q.Get("udid"),
+		SerialNumber: q.Get("serial_number"),
+		MessageType:  q.Get("message_type"),
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+	if lastID != "" && b.config.Store == nil {
+		http.Error(w, "resuming is not supported", http.StatusBadRequest)
+		return
+	}
+
+	// The client is subscribed before the archive is read, so that no
+	// event is missed between the two.
+	sub, err := b.subscribe(filter)
+	if err != nil {
+		b.config.Logger.Log("msg", "subscribe", "err", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer b.unsubscribe(sub)
+	var replay []*checkin.Event
+	if lastID != "" {
+		replay, err = b.replay(sub, lastID)
+		if err == errInvalidEventID {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			b.config.Logger.Log("msg", "replay", "last_event_id", lastID, "err", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var s sender
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := b.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has responded with an error.
+			return
+		}
+		defer conn.Close()
+		s = newWebSocketSender(conn)
+	} else {
+		if s, err = newSSESender(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := b.stream(sub, replay, s); err != nil {
+		b.config.Logger.Log("msg", "stream", "remote_addr", r.RemoteAddr, "err", err)
+	}
+}
+
+// replay returns the archived events matching the subscription which follow
+// the event with the given archive key, up to MaxReplay of the latest. Older
+// events are counted as dropped.
+func (b *Broker) replay(sub *subscription, lastID string) ([]*checkin.Event, error) {
+	nano, err := strconv.ParseInt(lastID, 10, 64)
+	if err != nil || nano < 0 {
+		return nil, errInvalidEventID
+	}
+	var events []*checkin.Event
+	max := b.config.MaxReplay
+	// archive keys are nanosecond timestamps, so the events which follow
+	// the key start at the next nanosecond.
+	filter := simple.EventFilter{Since: time.Unix(0, nano+1)}
+	err = b.config.Store.ForEachEvent(filter, func(e *checkin.Event) error {
+		if !sub.match(e) {
+			return nil
+		}
+		if len(events) == 2*max {
+			events = append(events[:0], events[max:]...)
+			atomic.AddUint64(&sub.dropped, uint64(max))
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > max {
+		atomic.AddUint64(&sub.dropped, uint64(len(events)-max))
+		events = events[len(events)-max:]
+	}
+	return events, nil
+}
+
+// stream sends the replayed events, then the events of the subscription,
+// until the client disconnects.
+func (b *Broker) stream(sub *subscription, replay []*checkin.Event, s sender) error {
+	if n := sub.takeDropped(); n > 0 {
+		if err := s.dropped(n); err != nil {
+			return err
+		}
+	}
+	replayed := make(map[string]bool, len(replay))
+	for _, e := range replay {
+		replayed[e.ID] = true
+		if err := s.event(e); err != nil {
+			return err
+		}
+	}
+	heartbeat := time.NewTicker(b.config.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-sub.events:
+			if replayed[e.ID] {
+				delete(replayed, e.ID)
+				continue
+			}
+			if n := sub.takeDropped(); n > 0 {
+				if err := s.dropped(n); err != nil {
+					return err
+				}
+			}
+			if err := s.event(e); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if err := s.heartbeat(); err != nil {
+				return err
+			}
+		case <-s.done():
+			return nil
+		}
+	}
+}
+
+// sender writes to a connected client.
+type sender interface {
+	event(e *checkin.Event) error
+	dropped(n uint64) error
+	heartbeat() error
+
+	// done is closed when the client disconnects.
+	done() <-chan struct{}
+}
+
+type sseSender struct {
+	w      http.ResponseWriter
+	f      http.Flusher
+	closed chan struct{}
+}
+
+func newSSESender(w http.ResponseWriter, r *http.Request) (*sseSender, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("stream: streaming is not supported by the ResponseWriter")
+	}
+	s := &sseSender{w: w, f: f, closed: make(chan struct{})}
+	var closeNotify <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closeNotify = cn.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closeNotify:
+		case <-r.Context().Done():
+		}
+		close(s.closed)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return s, nil
+}
+
+func (s *sseSender) event(e *checkin.Event) error {
+	data, err := json.Marshal(makeEvent(e))
+	if err != nil {
+		return err
+	}
+	return s.write("id: %s\ndata: %s\n\n", simple.EventKey(e), data)
+}
+
+func (s *sseSender) dropped(n uint64) error {
+	return s.write("event: dropped\ndata: {\"dropped\":%d}\n\n", n)
+}
+
+func (s *sseSender) heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *sseSender) write(format string, a ...interface{}) error {
+	if _, err := fmt.Fprintf(s.w, format, a...); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+func (s *sseSender) done() <-chan struct{} {
+	return s.closed
+}
+
+type webSocketSender struct {
+	conn   *websocket.Conn
+	closed chan struct{}
+}
+
+// message is a WebSocket message.
+type message struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+func newWebSocketSender(conn *websocket.Conn) *webSocketSender {
+	s := &webSocketSender{conn: conn, closed: make(chan struct{})}
+	// Messages from the client are discarded. Reading processes the close
+	// and pong messages, and fails once the client disconnects.
+	go func() {
+		defer close(s.closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *webSocketSender) event(e *checkin.Event) error {
+	event := makeEvent(e)
+	return s.write(message{Type: "checkin", ID: simple.EventKey(e), Event: &event})
+}
+
+func (s *webSocketSender) dropped(n uint64) error {
+	return s.write(message{Type: "dropped", Dropped: n})
+}
+
+func (s *webSocketSender) heartbeat() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+}
+
+func (s *webSocketSender) write(m message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return s.conn.WriteJSON(m)
+}
+
+func (s *webSocketSender) done() <-chan struct{} {
+	return s.closed
+}
@@ -0,0 +1,179 @@
+package checkin
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"golang.org/x/net/context"
+)
+
+// TraceHTTPHandler wraps an HTTP handler with a server span. The span
+// continues the trace of the incoming request headers, if any, and is
+// finished with the response status code once the handler returns.
+//
+// The span is stored in the request context. Pass SpanFromHTTPRequest to
+// the handlers returned by MakeHTTPHandlers, so that it becomes the parent
+// of the spans started for the check-in.
+func TraceHTTPHandler(tracer opentracing.Tracer, operationName string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wireContext, _ := tracer.Extract(
+			opentracing.HTTPHeaders,
+			opentracing.HTTPHeadersCarrier(r.Header),
+		)
+		span := tracer.StartSpan(operationName, ext.RPCServerOption(wireContext))
+		defer span.Finish()
+		ext.HTTPMethod.Set(span, r.Method)
+		ext.HTTPUrl.Set(span, r.URL.String())
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw.wrap(), r.WithContext(opentracing.ContextWithSpan(r.Context(), span)))
+
+		ext.HTTPStatusCode.Set(span, uint16(sw.status))
+		if sw.status >= http.StatusBadRequest {
+			ext.Error.Set(span, true)
+		}
+	})
+}
+
+// SpanFromHTTPRequest returns a RequestFunc which copies the span started
+// by TraceHTTPHandler from the request into the go-kit context.
+func SpanFromHTTPRequest() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if span := opentracing.SpanFromContext(r.Context()); span != nil {
+			return opentracing.ContextWithSpan(ctx, span)
+		}
+		return ctx
+	}
+}
+
+// EndpointTracingMiddleware returns an endpoint middleware which runs each
+// invocation in a child span of the span in the context. The span is tagged
+// with the MessageType of the check-in, and marked as failed if the
+// check-in returns an error. Invocations without a span in the context are
+// not traced.
+func EndpointTracingMiddleware(operationName string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			span, ctx := startSpan(ctx, operationName)
+			if span == nil {
+				return next(ctx, request)
+			}
+			defer span.Finish()
+			if req, ok := request.(checkinRequest); ok {
+				span.SetTag("message_type", req.MessageType)
+			}
+
+			response, err = next(ctx, request)
+			if e, ok := response.(errorer); ok && err == nil {
+				err = e.error()
+			}
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogFields(otlog.Error(err))
+			}
+			return response, err
+		}
+	}
+}
+
+// InjectTrace sets the trace context of the span in ctx on the event, so that
+// consumers of the published event can continue the trace with ExtractTrace.
+// The event is not changed if ctx has no span.
+func InjectTrace(ctx context.Context, e *Event) error {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		return err
+	}
+	e.Trace = carrier
+	return nil
+}
+
+// ExtractTrace returns the trace context of the check-in which created the
+// event. It returns opentracing.ErrSpanContextNotFound if the check-in was
+// not traced.
+func ExtractTrace(tracer opentracing.Tracer, e *Event) (opentracing.SpanContext, error) {
+	return tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier(e.Trace))
+}
+
+// startSpan starts a child span of the span in ctx, using the tracer of the
+// parent span. It returns a nil span if ctx has no span.
+func startSpan(ctx context.Context, operationName string) (opentracing.Span, context.Context) {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil, ctx
+	}
+	span := parent.Tracer().StartSpan(operationName, opentracing.ChildOf(parent.Context()))
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// wrap returns w as a ResponseWriter which also implements the optional
+// http.Flusher, http.Hijacker and http.CloseNotifier interfaces implemented
+// by the underlying ResponseWriter, so that handlers which stream or hijack
+// the connection keep working when traced.
+func (w *statusWriter) wrap() http.ResponseWriter {
+	f, isFlusher := w.ResponseWriter.(http.Flusher)
+	h, isHijacker := w.ResponseWriter.(http.Hijacker)
+	cn, isCloseNotifier := w.ResponseWriter.(http.CloseNotifier)
+	switch {
+	case isFlusher && isHijacker && isCloseNotifier:
+		return struct {
+			*statusWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{w, f, h, cn}
+	case isFlusher && isHijacker:
+		return struct {
+			*statusWriter
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case isFlusher && isCloseNotifier:
+		return struct {
+			*statusWriter
+			http.Flusher
+			http.CloseNotifier
+		}{w, f, cn}
+	case isHijacker && isCloseNotifier:
+		return struct {
+			*statusWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{w, h, cn}
+	case isFlusher:
+		return struct {
+			*statusWriter
+			http.Flusher
+		}{w, f}
+	case isHijacker:
+		return struct {
+			*statusWriter
+			http.Hijacker
+		}{w, h}
+	case isCloseNotifier:
+		return struct {
+			*statusWriter
+			http.CloseNotifier
+		}{w, cn}
+	}
+	return w
+}
@@ -0,0 +1,147 @@
+package checkin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"golang.org/x/net/context"
+)
+
+func TestTracing(t *testing.T) {
+	tracer := mocktracer.New()
+	var event *Event
+	svc := &mock.CheckinService{
+		TokenUpdateFunc: func(ctx context.Context, cmd mdm.CheckinCommand) error {
+			event = NewEventWithContext(ctx, cmd)
+			return nil
+		},
+		CheckoutFunc: mock.FailCheckin,
+	}
+	e := EndpointTracingMiddleware("checkin")(MakeCheckinEndpoint(svc))
+	h := MakeHTTPHandlers(
+		context.Background(),
+		Endpoints{CheckinEndpoint: e},
+		httptransport.ServerErrorEncoder(EncodeError),
+		httptransport.ServerBefore(SpanFromHTTPRequest()),
+	)
+	srv := httptest.NewServer(TraceHTTPHandler(tracer, "http", h.CheckinHandler))
+	defer srv.Close()
+
+	// the device's request continues the trace of a client span.
+	client := tracer.StartSpan("client")
+	req, _ := http.NewRequest("PUT", srv.URL, mustMarshalCheckinRequest(t, "TokenUpdate"))
+	if err := tracer.Inject(client.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	client.Finish()
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	parents := map[string]string{"decode": "http", "checkin": "http", "http": "client"}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %q not finished", name)
+		}
+		if have, want := span.ParentID, spans[parent].SpanContext.SpanID; have != want {
+			t.Errorf("span %q: want parent %q (%d), have %d", name, parent, want, have)
+		}
+		if have, want := span.SpanContext.TraceID, spans["client"].SpanContext.TraceID; have != want {
+			t.Errorf("span %q: want trace %d, have %d", name, want, have)
+		}
+	}
+	if have := spans["checkin"].Tag("message_type"); have != "TokenUpdate" {
+		t.Errorf("want message_type tag TokenUpdate, have %v", have)
+	}
+	if have := spans["http"].Tag("http.status_code"); have != uint16(200) {
+		t.Errorf("want http.status_code 200, have %v", have)
+	}
+
+	if event == nil {
+		t.Fatal("service not invoked")
+	}
+	sc, err := ExtractTrace(tracer, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := sc.(mocktracer.MockSpanContext).SpanID, spans["checkin"].SpanContext.SpanID; have != want {
+		t.Errorf("event trace: want span %d, have %d", want, have)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		tracer.Reset()
+		resp, err := http.Post(srv.URL, "application/x-apple-aspen-mdm-checkin", mustMarshalCheckinRequest(t, "CheckOut"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		for _, span := range tracer.FinishedSpans() {
+			if span.OperationName == "decode" {
+				continue
+			}
+			if span.Tag("error") != true {
+				t.Errorf("span %q not marked as failed", span.OperationName)
+			}
+		}
+	})
+
+	t.Run("untraced_event", func(t *testing.T) {
+		e := NewEventWithContext(context.Background(), mdm.CheckinCommand{MessageType: "CheckOut"})
+		if e.Trace != nil {
+			t.Errorf("want no trace context, have %v", e.Trace)
+		}
+		if _, err := ExtractTrace(tracer, e); err != opentracing.ErrSpanContextNotFound {
+			t.Errorf("want ErrSpanContextNotFound, have %v", err)
+		}
+	})
+
+	t.Run("decode_error", func(t *testing.T) {
+		tracer.Reset()
+		resp, err := http.Post(srv.URL, "application/x-apple-aspen-mdm-checkin", strings.NewReader("not a plist"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		var decodes []*mocktracer.MockSpan
+		for _, span := range tracer.FinishedSpans() {
+			if span.OperationName == "decode" {
+				decodes = append(decodes, span)
+			}
+		}
+		if len(decodes) != 1 {
+			t.Fatalf("want 1 decode span, have %d", len(decodes))
+		}
+		if decodes[0].Tag("error") != true {
+			t.Error("decode span not marked as failed")
+		}
+	})
+}
+
+func TestTraceHTTPHandler_optionalInterfaces(t *testing.T) {
+	var flusher, closeNotifier bool
+	h := TraceHTTPHandler(mocktracer.New(), "http", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, closeNotifier = w.(http.CloseNotifier)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !flusher {
+		t.Error("http.Flusher of the ResponseWriter not forwarded")
+	}
+	if closeNotifier {
+		t.Error("http.CloseNotifier implemented, but the ResponseWriter doesn't")
+	}
+}
@@ -0,0 +1,89 @@
+package checkin
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/groob/plist"
+)
+
+// CommandContentType is the Content-Type of command results sent by devices.
+const CommandContentType = "application/x-apple-aspen-mdm"
+
+// CombinedHandler serves check-ins and MDM command results from a single
+// URL, for enrollment profiles which set the same CheckInURL and ServerURL.
+//
+// Requests with the CheckinContentType are check-ins, and requests with the
+// CommandContentType are command results. Other requests are told apart by
+// their body: a body with a MessageType is a check-in, and a body with a
+// Status or CommandUUID is a command result. A body larger than a check-in
+// can only be a command result, such as one carrying a large list of
+// installed applications, and is passed to the Commands handler. Other
+// requests are passed to the Checkin handler, which rejects them.
+type CombinedHandler struct {
+	// Checkin serves check-ins, such as the CheckinHandler of
+	// HTTPHandlers.
+	Checkin http.Handler
+
+	// Commands serves command results.
+	Commands http.Handler
+
+	// MaxCheckinSize is the size limit of check-in bodies, in bytes. A
+	// larger body without the CheckinContentType is passed to the Commands
+	// handler without being parsed. It should match the MaxBodySize of
+	// the Checkin handler. Defaults to DefaultMaxBodySize.
+	MaxCheckinSize int64
+}
+
+// combinedRequest holds the fields which tell check-ins and command
+// results apart.
+type combinedRequest struct {
+	MessageType string
+	Status      string
+	CommandUUID string
+}
+
+func (h *CombinedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		switch mediaType {
+		case CheckinContentType:
+			h.Checkin.ServeHTTP(w, r)
+			return
+		case CommandContentType:
+			h.Commands.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	max := h.MaxCheckinSize
+	if max <= 0 {
+		max = DefaultMaxBodySize
+	}
+	// Read one byte more than a check-in can hold. The body read so far is
+	// put back in front of the rest, so that the next handler reads the
+	// whole body.
+	peek, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		http.Error(w, "read request body", http.StatusBadRequest)
+		return
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), r.Body), r.Body}
+
+	if int64(len(peek)) > max {
+		h.Commands.ServeHTTP(w, r)
+		return
+	}
+	var req combinedRequest
+	if err := plist.Unmarshal(peek, &req); err == nil && req.MessageType == "" &&
+		(req.Status != "" || req.CommandUUID != "") {
+		h.Commands.ServeHTTP(w, r)
+		return
+	}
+	h.Checkin.ServeHTTP(w, r)
+}
@@ -0,0 +1,101 @@
+package checkin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/groob/plist"
+)
+
+func TestCombinedHandler(t *testing.T) {
+	var handled string
+	var body []byte
+	record := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled = name
+			body, _ = ioutil.ReadAll(r.Body)
+		})
+	}
+	h := &CombinedHandler{
+		Checkin:        record("checkin"),
+		Commands:       record("commands"),
+		MaxCheckinSize: 1000,
+	}
+
+	commandResult := func(fields map[string]string) []byte {
+		buf := new(bytes.Buffer)
+		if err := plist.NewEncoder(buf).Encode(fields); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        string
+	}{
+		{
+			name:        "checkin_content_type",
+			contentType: CheckinContentType,
+			body:        commandResult(map[string]string{"Status": "Idle"}),
+			want:        "checkin",
+		},
+		{
+			name: "checkin_body",
+			body: mustMarshalCheckinRequest(t, "TokenUpdate").Bytes(),
+			want: "checkin",
+		},
+		{
+			name: "idle",
+			body: commandResult(map[string]string{"Status": "Idle", "UDID": "some-device"}),
+			want: "commands",
+		},
+		{
+			name: "command_result",
+			body: commandResult(map[string]string{"Status": "Acknowledged", "CommandUUID": "1234"}),
+			want: "commands",
+		},
+		{
+			name:        "large_command_result",
+			contentType: CommandContentType,
+			body:        commandResult(map[string]string{"Status": "Acknowledged", "Data": strings.Repeat("a", 2000)}),
+			want:        "commands",
+		},
+		{
+			name: "large_body",
+			body: commandResult(map[string]string{"Status": "Acknowledged", "Data": strings.Repeat("a", 2000)}),
+			want: "commands",
+		},
+		{
+			name:        "large_checkin",
+			contentType: CheckinContentType,
+			body:        commandResult(map[string]string{"MessageType": "TokenUpdate", "Data": strings.Repeat("a", 2000)}),
+			want:        "checkin",
+		},
+		{
+			name: "invalid_body",
+			body: []byte("not a plist"),
+			want: "checkin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/mdm", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if handled != tt.want {
+				t.Errorf("want request served by %s, have %s", tt.want, handled)
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("handler read a different body than was sent")
+			}
+		})
+	}
+}
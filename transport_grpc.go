@@ -0,0 +1,185 @@
+package checkin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+
+	"github.com/micromdm/checkin/internal/checkinproto"
+)
+
+// RegisterGRPCServer registers a gRPC server for the endpoints with s.
+//
+// Check-in errors are returned in the response message instead of as gRPC
+// errors, so that a client created with NewGRPCClient returns the same
+// *Error as the remote service.
+//
+// The go-kit gRPC server calls the endpoint with ctx instead of the context
+// of the call. The endpoint is instead called with a context which carries
+// the values of ctx, and the deadline and cancellation of the call, so that
+// a check-in abandoned by the client is not processed to the end.
+func RegisterGRPCServer(ctx context.Context, s *grpc.Server, endpoints Endpoints, opts ...grpctransport.ServerOption) {
+	checkinproto.RegisterCheckinServer(s, &grpcServer{
+		ctx:      ctx,
+		endpoint: endpoints.CheckinEndpoint,
+		opts:     opts,
+	})
+}
+
+type grpcServer struct {
+	ctx      context.Context
+	endpoint endpoint.Endpoint
+	opts     []grpctransport.ServerOption
+}
+
+func (s *grpcServer) Checkin(ctx context.Context, req *checkinproto.CheckinRequest) (*checkinproto.CheckinResponse, error) {
+	// a go-kit server is created for every call, as it only takes its
+	// context when it is created.
+	checkin := grpctransport.NewServer(
+		callContext{Context: s.ctx, call: ctx},
+		s.endpoint,
+		decodeGRPCRequest,
+		encodeGRPCResponse,
+		s.opts...,
+	)
+	_, resp, err := checkin.ServeGRPC(ctx, req)
+	if e, ok := err.(grpctransport.BadRequestError); ok {
+		return errorToProto(&Error{Kind: KindValidation, Err: e.Err}), nil
+	}
+	if err != nil {
+		return errorToProto(err), nil
+	}
+	return resp.(*checkinproto.CheckinResponse), nil
+}
+
+// callContext is a context with the values of the server's context, and the
+// deadline and cancellation of a gRPC call.
+type callContext struct {
+	context.Context
+	call context.Context
+}
+
+func (c callContext) Deadline() (time.Time, bool) { return c.call.Deadline() }
+func (c callContext) Done() <-chan struct{}       { return c.call.Done() }
+func (c callContext) Err() error                  { return c.call.Err() }
+
+func decodeGRPCRequest(ctx context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*checkinproto.CheckinRequest)
+	if req.Command == nil {
+		return nil, errors.New("missing command")
+	}
+	checkinReq := checkinRequest{CheckinCommand: commandFromProto(req.Command)}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		checkinReq.remoteAddr = p.Addr.String()
+		if host, _, err := net.SplitHostPort(checkinReq.remoteAddr); err == nil {
+			checkinReq.remoteAddr = host
+		}
+	}
+	return checkinReq, nil
+}
+
+func encodeGRPCResponse(ctx context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return errorToProto(e.error()), nil
+	}
+	return &checkinproto.CheckinResponse{}, nil
+}
+
+// errorToProto returns a response carrying err, and the Kind and RetryAfter
+// of an *Error. An *Error without an Err carries the name of its Kind, because
+// a response with an empty Err is a success.
+func errorToProto(err error) *checkinproto.CheckinResponse {
+	e, ok := err.(*Error)
+	if !ok {
+		return &checkinproto.CheckinResponse{Err: err.Error()}
+	}
+	msg := e.Kind.String()
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	return &checkinproto.CheckinResponse{
+		Err:               msg,
+		ErrKind:           e.Kind.String(),
+		RetryAfterSeconds: int64((e.RetryAfter + time.Second - 1) / time.Second),
+	}
+}
+
+// NewGRPCClient returns a Service which forwards check-ins to the gRPC server
+// at the other end of conn, such as one registered with RegisterGRPCServer.
+// Check-ins which fail on the server return an *Error if the server returned
+// an error with a Kind.
+func NewGRPCClient(conn *grpc.ClientConn, opts ...grpctransport.ClientOption) Service {
+	return grpcClient{
+		checkin: grpctransport.NewClient(
+			conn,
+			"checkinproto.Checkin",
+			"Checkin",
+			encodeGRPCRequest,
+			decodeGRPCResponse,
+			checkinproto.CheckinResponse{},
+			opts...,
+		).Endpoint(),
+	}
+}
+
+type grpcClient struct {
+	checkin endpoint.Endpoint
+}
+
+func (c grpcClient) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, "Authenticate", cmd)
+}
+
+func (c grpcClient) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, "TokenUpdate", cmd)
+}
+
+func (c grpcClient) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, "CheckOut", cmd)
+}
+
+// do forwards a check-in passed to the named method. The server dispatches
+// on the MessageType, so a check-in of another MessageType is rejected
+// instead of being handled as one.
+func (c grpcClient) do(ctx context.Context, method string, cmd mdm.CheckinCommand) error {
+	if cmd.MessageType != method {
+		return &Error{
+			Kind: KindValidation,
+			Err:  fmt.Errorf("expected %s, got %s MessageType", method, cmd.MessageType),
+		}
+	}
+	response, err := c.checkin(ctx, cmd)
+	if err != nil {
+		return &Error{Kind: KindUpstreamUnavailable, Err: err}
+	}
+	return response.(checkinResponse).Err
+}
+
+func encodeGRPCRequest(ctx context.Context, request interface{}) (interface{}, error) {
+	return &checkinproto.CheckinRequest{Command: commandToProto(request.(mdm.CheckinCommand))}, nil
+}
+
+func decodeGRPCResponse(ctx context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*checkinproto.CheckinResponse)
+	if reply.Err == "" {
+		return checkinResponse{}, nil
+	}
+	err := errors.New(reply.Err)
+	kind, ok := kindFromString(reply.ErrKind)
+	if !ok {
+		return checkinResponse{Err: err}, nil
+	}
+	return checkinResponse{Err: &Error{
+		Kind:       kind,
+		Err:        err,
+		RetryAfter: time.Duration(reply.RetryAfterSeconds) * time.Second,
+	}}, nil
+}
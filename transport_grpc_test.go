@@ -0,0 +1,127 @@
+package checkin_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestGRPC(t *testing.T) {
+	var received mdm.CheckinCommand
+	record := func(ctx context.Context, cmd mdm.CheckinCommand) error {
+		received = cmd
+		return nil
+	}
+	svc := &mock.CheckinService{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	checkin.RegisterGRPCServer(context.Background(), s, checkin.Endpoints{CheckinEndpoint: checkin.MakeCheckinEndpoint(svc)})
+	go s.Serve(ln)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := checkin.NewGRPCClient(conn)
+	ctx := context.Background()
+
+	t.Run("forward", func(t *testing.T) {
+		svc.AuthenticateFunc = record
+		svc.TokenUpdateFunc = record
+		for _, name := range []string{"Authenticate", "TokenUpdate"} {
+			cmd := mustLoadCommand(t, name)
+			var err error
+			if name == "Authenticate" {
+				err = client.Authenticate(ctx, cmd)
+			} else {
+				err = client.TokenUpdate(ctx, cmd)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !reflect.DeepEqual(received, cmd) {
+				t.Errorf("%s:\nwant: %#v\nhave: %#v", name, cmd, received)
+			}
+		}
+	})
+
+	t.Run("typed_error", func(t *testing.T) {
+		svc.CheckoutFunc = func(context.Context, mdm.CheckinCommand) error {
+			return &checkin.Error{
+				Kind:       checkin.KindStorageUnavailable,
+				Err:        errors.New("disk full"),
+				RetryAfter: 2 * time.Second,
+			}
+		}
+		err := client.CheckOut(ctx, mustLoadCommand(t, "CheckOut"))
+		e, ok := err.(*checkin.Error)
+		if !ok {
+			t.Fatalf("want *Error, have %#v", err)
+		}
+		if e.Kind != checkin.KindStorageUnavailable || e.Err.Error() != "disk full" || e.RetryAfter != 2*time.Second {
+			t.Errorf("want storage_unavailable: disk full, retry after 2s, have %v, retry after %s", e, e.RetryAfter)
+		}
+	})
+
+	t.Run("typed_error_without_err", func(t *testing.T) {
+		svc.CheckoutFunc = func(context.Context, mdm.CheckinCommand) error {
+			return &checkin.Error{Kind: checkin.KindUpstreamUnavailable}
+		}
+		err := client.CheckOut(ctx, mustLoadCommand(t, "CheckOut"))
+		if kind, _ := checkin.ErrorKind(err); err == nil || kind != checkin.KindUpstreamUnavailable {
+			t.Errorf("want upstream_unavailable error, have %v", err)
+		}
+	})
+
+	t.Run("untyped_error", func(t *testing.T) {
+		svc.CheckoutFunc = mock.FailCheckin
+		err := client.CheckOut(ctx, mustLoadCommand(t, "CheckOut"))
+		if _, typed := checkin.ErrorKind(err); err == nil || typed {
+			t.Errorf("want untyped error, have %#v", err)
+		}
+	})
+
+	t.Run("method_mismatch", func(t *testing.T) {
+		var called bool
+		svc.CheckoutFunc = func(context.Context, mdm.CheckinCommand) error {
+			called = true
+			return nil
+		}
+		err := client.CheckOut(ctx, mustLoadCommand(t, "Authenticate"))
+		if kind, _ := checkin.ErrorKind(err); err == nil || kind != checkin.KindValidation {
+			t.Errorf("want validation error, have %v", err)
+		}
+		if called {
+			t.Error("mismatched check-in was forwarded")
+		}
+	})
+
+	t.Run("call_deadline", func(t *testing.T) {
+		var hasDeadline bool
+		svc.CheckoutFunc = func(ctx context.Context, cmd mdm.CheckinCommand) error {
+			_, hasDeadline = ctx.Deadline()
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if err := client.CheckOut(ctx, mustLoadCommand(t, "CheckOut")); err != nil {
+			t.Fatal(err)
+		}
+		if !hasDeadline {
+			t.Error("the deadline of the call was not passed to the service")
+		}
+	})
+}
@@ -1,25 +1,90 @@
 package checkin
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/groob/plist"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 	"golang.org/x/net/context"
 )
 
 type HTTPHandlers struct {
-	// The CheckinHandler should accept PUT requests
+	// The CheckinHandler should accept PUT requests. Use HTTPConfig to
+	// reject other methods.
 	CheckinHandler http.Handler
 }
 
+// CheckinContentType is the Content-Type of check-in requests sent by devices.
+const CheckinContentType = "application/x-apple-aspen-mdm-checkin"
+
+// DefaultMaxBodySize is the size limit of check-in request bodies, in bytes,
+// if HTTPConfig.MaxBodySize is not set.
+const DefaultMaxBodySize = 10000
+
+// HTTPConfig configures the requests accepted by the HTTP handlers.
+type HTTPConfig struct {
+	// Methods are the allowed HTTP methods. Requests with a different
+	// method are rejected with 405 (Method Not Allowed). If empty, any
+	// method is allowed. Devices send check-ins with PUT.
+	Methods []string
+
+	// ContentType is the expected media type of the request body, such as
+	// CheckinContentType. Requests with a different Content-Type are
+	// rejected with 415 (Unsupported Media Type). If empty, any
+	// Content-Type is allowed.
+	ContentType string
+
+	// MaxBodySize is the size limit of the request body, in bytes. Larger
+	// requests are rejected with 413 (Request Entity Too Large).
+	// Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// Logger logs rejected requests. Optional.
+	Logger log.Logger
+
+	// Rejected counts rejected requests, labeled by "reason", which is
+	// "method", "content_type" or "body_size". Optional.
+	Rejected metrics.Counter
+}
+
+// MakeHTTPHandlers returns the HTTP handlers for the endpoints, accepting any
+// method and Content-Type, with bodies of up to DefaultMaxBodySize bytes.
+// Errors are encoded with EncodeError, unless a different
+// ServerErrorEncoder is passed in opts.
 func MakeHTTPHandlers(ctx context.Context, endpoints Endpoints, opts ...httptransport.ServerOption) HTTPHandlers {
+	return MakeHTTPHandlersWithConfig(ctx, endpoints, HTTPConfig{}, opts...)
+}
+
+// MakeHTTPHandlersWithConfig returns the HTTP handlers for the endpoints,
+// rejecting requests which don't match config.
+func MakeHTTPHandlersWithConfig(ctx context.Context, endpoints Endpoints, config HTTPConfig, opts ...httptransport.ServerOption) HTTPHandlers {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Rejected == nil {
+		config.Rejected = discard.NewCounter()
+	}
+	opts = append([]httptransport.ServerOption{httptransport.ServerErrorEncoder(EncodeError)}, opts...)
 	h := HTTPHandlers{
 		CheckinHandler: httptransport.NewServer(
 			ctx,
 			endpoints.CheckinEndpoint,
-			decodeRequest,
+			makeDecodeRequest(config),
 			encodeResponse,
 			opts...,
 		),
@@ -35,18 +100,135 @@ type errorWrapper struct {
 	Error string `json:"error"`
 }
 
-func decodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+// makeDecodeRequest returns a DecodeRequestFunc which rejects requests that
+// don't match config. Rejected requests are logged and counted.
+func makeDecodeRequest(config HTTPConfig) httptransport.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		if err := checkRequest(config, r); err != nil {
+			return nil, reject(config, r, err)
+		}
+		req, err := decodeRequest(ctx, r, config.MaxBodySize)
+		if e, ok := err.(*requestError); ok {
+			return nil, reject(config, r, e)
+		}
+		return req, err
+	}
+}
+
+func reject(config HTTPConfig, r *http.Request, err *requestError) error {
+	config.Rejected.With("reason", err.reason).Add(1)
+	config.Logger.Log(
+		"msg", "rejected check-in request",
+		"reason", err.reason,
+		"method", r.Method,
+		"content_type", r.Header.Get("Content-Type"),
+		"content_length", r.ContentLength,
+		"remote_addr", r.RemoteAddr,
+		"err", err,
+	)
+	return err
+}
+
+// checkRequest returns a *requestError if the method or Content-Type of r
+// is not allowed, or its body is known to be too large.
+func checkRequest(config HTTPConfig, r *http.Request) *requestError {
+	if len(config.Methods) > 0 && !containsString(config.Methods, r.Method) {
+		return &requestError{
+			reason: "method",
+			status: http.StatusMethodNotAllowed,
+			header: http.Header{"Allow": []string{strings.Join(config.Methods, ", ")}},
+			err:    fmt.Errorf("method %s not allowed", r.Method),
+		}
+	}
+	if config.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != config.ContentType {
+			return &requestError{
+				reason: "content_type",
+				status: http.StatusUnsupportedMediaType,
+				err:    fmt.Errorf("unsupported Content-Type %q", r.Header.Get("Content-Type")),
+			}
+		}
+	}
+	if r.ContentLength > config.MaxBodySize {
+		return errBodyTooLarge(config.MaxBodySize)
+	}
+	return nil
+}
+
+func decodeRequest(ctx context.Context, r *http.Request, maxBodySize int64) (request interface{}, err error) {
+	if span, _ := startSpan(ctx, "decode"); span != nil {
+		defer func() {
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogFields(otlog.Error(err))
+			}
+			span.Finish()
+		}()
+	}
+	// Read one byte more than allowed, to tell a body of exactly
+	// maxBodySize bytes from a larger one.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, &Error{Kind: KindValidation, Err: err}
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, errBodyTooLarge(maxBodySize)
+	}
 	var req checkinRequest
-	err := plist.NewDecoder(io.LimitReader(r.Body, 10000)).Decode(&req)
-	return req, err
+	if err := plist.NewDecoder(bytes.NewReader(body)).Decode(&req); err != nil {
+		return nil, &Error{Kind: KindValidation, Err: err}
+	}
+	req.remoteAddr = r.RemoteAddr
+	if host, _, splitErr := net.SplitHostPort(r.RemoteAddr); splitErr == nil {
+		req.remoteAddr = host
+	}
+	// proxies may append to the header or add another one.
+	for _, fwd := range r.Header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(fwd, ",") {
+			req.forwardedFor = append(req.forwardedFor, strings.TrimSpace(addr))
+		}
+	}
+	return req, nil
+}
+
+// requestError is a request rejected by the transport before it is decoded.
+type requestError struct {
+	reason string
+	status int
+	header http.Header
+	err    error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+
+func (e *requestError) StatusCode() int { return e.status }
+
+func (e *requestError) Headers() http.Header { return e.header }
+
+func errBodyTooLarge(max int64) *requestError {
+	return &requestError{
+		reason: "body_size",
+		status: http.StatusRequestEntityTooLarge,
+		err:    fmt.Errorf("request body larger than %d bytes", max),
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 // According to the MDM Check-in protocol, the server must respond with 200 OK
-// to successful Check-in requests.
+// to successful Check-in requests. Failed check-ins are returned to the
+// transport, which encodes them with its ServerErrorEncoder.
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
-		EncodeError(ctx, e.error(), w)
-		return nil
+		return e.error()
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -57,7 +239,43 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 // The EncodeError should be passed to the Go-Kit httptransport as the
 // ServerErrorEncoder to encode error responses.
 // According to the MDM Check-in protocol specification, the device only needs
-// a 401 (Unauthorized) response in case of failure.
+// a 401 (Unauthorized) response in case of failure, but a device treats it as
+// an authentication failure. EncodeError responds to an *Error with the status
+// code of its Kind instead, so that transient server failures are retried.
+// Errors returned by endpoint middlewares, such as EndpointRateLimitMiddleware,
+// can also set a different status code and headers. All other errors are
+// encoded as 401. Use EncodeStrictError to always respond with 401.
 func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if e, ok := err.(httptransport.Error); ok {
+		err = e.Err
+	}
+	if h, ok := err.(headerer); ok {
+		for k, v := range h.Headers() {
+			w.Header()[k] = v
+		}
+	}
+	if sc, ok := err.(statusCoder); ok {
+		w.WriteHeader(sc.StatusCode())
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// EncodeStrictError encodes every error as 401 (Unauthorized), without
+// headers, as required by the MDM Check-in protocol specification. It can
+// be passed to the Go-Kit httptransport as the ServerErrorEncoder instead of
+// EncodeError.
+func EncodeStrictError(ctx context.Context, err error, w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 }
+
+// statusCoder is implemented by errors which are encoded with their own
+// HTTP status code.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// headerer is implemented by errors which add headers to the response.
+type headerer interface {
+	Headers() http.Header
+}
@@ -0,0 +1,187 @@
+package checkin
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/groob/plist"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+
+	"github.com/micromdm/checkin/internal/mdmsig"
+)
+
+// ClientOption configures the Service returned by NewHTTPClient.
+type ClientOption func(*httpClientConfig)
+
+type httpClientConfig struct {
+	client    *http.Client
+	tlsConfig *tls.Config
+	cert      *x509.Certificate
+	key       *rsa.PrivateKey
+	opts      []httptransport.ClientOption
+}
+
+// WithHTTPClient sends check-ins with client instead of a new *http.Client.
+// It can't be combined with WithTLSConfig.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *httpClientConfig) {
+		c.client = client
+	}
+}
+
+// WithTLSConfig connects to the server with config, for example to present
+// a client certificate for mutual TLS.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *httpClientConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// WithSigner signs every check-in with the device identity cert and key,
+// like a device does, and sends the detached CMS signature in the
+// Mdm-Signature header.
+func WithSigner(cert *x509.Certificate, key *rsa.PrivateKey) ClientOption {
+	return func(c *httpClientConfig) {
+		c.cert = cert
+		c.key = key
+	}
+}
+
+// WithClientOptions passes options to the go-kit HTTP client.
+func WithClientOptions(opts ...httptransport.ClientOption) ClientOption {
+	return func(c *httpClientConfig) {
+		c.opts = append(c.opts, opts...)
+	}
+}
+
+// NewHTTPClient returns a Service which PUTs check-ins to checkinURL in the
+// wire format of the MDM Check-in protocol.
+//
+// A check-in which the server responds to with 200 OK succeeds. Other
+// responses return an *Error, so that an edge proxy which forwards check-ins
+// with this client and encodes errors with EncodeError responds like the
+// server did: 400, 401 and 404 return the Kind which EncodeError maps to the
+// status code, and 5xx responses and failed requests return an *Error of
+// KindUpstreamUnavailable. A 429 response keeps its status code. The
+// Retry-After header of the response is kept.
+func NewHTTPClient(checkinURL string, opts ...ClientOption) (Service, error) {
+	u, err := url.Parse(checkinURL)
+	if err != nil {
+		return nil, err
+	}
+	var config httpClientConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.tlsConfig != nil {
+		if config.client != nil {
+			return nil, errors.New("checkin: WithHTTPClient can't be combined with WithTLSConfig")
+		}
+		config.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config.tlsConfig,
+			},
+		}
+	}
+	clientOpts := config.opts
+	if config.client != nil {
+		clientOpts = append([]httptransport.ClientOption{httptransport.SetClient(config.client)}, clientOpts...)
+	}
+	return httpClient{
+		checkin: httptransport.NewClient(
+			"PUT",
+			u,
+			makeEncodeHTTPRequest(config),
+			decodeHTTPResponse,
+			clientOpts...,
+		).Endpoint(),
+	}, nil
+}
+
+type httpClient struct {
+	checkin endpoint.Endpoint
+}
+
+func (c httpClient) Authenticate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, cmd)
+}
+
+func (c httpClient) TokenUpdate(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, cmd)
+}
+
+func (c httpClient) CheckOut(ctx context.Context, cmd mdm.CheckinCommand) error {
+	return c.do(ctx, cmd)
+}
+
+func (c httpClient) do(ctx context.Context, cmd mdm.CheckinCommand) error {
+	response, err := c.checkin(ctx, cmd)
+	if e, ok := err.(httptransport.Error); ok && e.Domain == httptransport.DomainDo {
+		return &Error{Kind: KindUpstreamUnavailable, Err: e.Err}
+	}
+	if err != nil {
+		return err
+	}
+	return response.(checkinResponse).Err
+}
+
+// makeEncodeHTTPRequest returns an EncodeRequestFunc which encodes a check-in
+// as a plist, and signs it if the config has a signer.
+func makeEncodeHTTPRequest(config httpClientConfig) httptransport.EncodeRequestFunc {
+	return func(ctx context.Context, r *http.Request, request interface{}) error {
+		cmd := request.(mdm.CheckinCommand)
+		buf := new(bytes.Buffer)
+		if err := plist.NewEncoder(buf).Encode(&cmd); err != nil {
+			return err
+		}
+		body := buf.Bytes()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", CheckinContentType)
+		if config.cert != nil {
+			return mdmsig.SignRequest(r, body, config.cert, config.key)
+		}
+		return nil
+	}
+}
+
+func decodeHTTPResponse(ctx context.Context, r *http.Response) (interface{}, error) {
+	io.Copy(ioutil.Discard, r.Body)
+	if r.StatusCode == http.StatusOK {
+		return checkinResponse{}, nil
+	}
+	err := fmt.Errorf("check-in failed with status %s", r.Status)
+	var retryAfter time.Duration
+	if secs, parseErr := strconv.Atoi(r.Header.Get("Retry-After")); parseErr == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	switch r.StatusCode {
+	case http.StatusBadRequest:
+		err = &Error{Kind: KindValidation, Err: err}
+	case http.StatusUnauthorized:
+		err = &Error{Kind: KindUnauthorized, Err: err}
+	case http.StatusNotFound:
+		err = &Error{Kind: KindUnsupportedMessageType, Err: err}
+	case http.StatusTooManyRequests:
+		err = rateLimitError{status: r.StatusCode, retryAfter: retryAfter}
+	default:
+		if r.StatusCode >= http.StatusInternalServerError {
+			err = &Error{Kind: KindUpstreamUnavailable, Err: err, RetryAfter: retryAfter}
+		}
+	}
+	return checkinResponse{Err: err}, nil
+}
@@ -0,0 +1,160 @@
+package checkin_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/internal/mdmsig"
+	"github.com/micromdm/checkin/service/mock"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestHTTPClient(t *testing.T) {
+	var received mdm.CheckinCommand
+	svc := &mock.CheckinService{
+		TokenUpdateFunc: func(ctx context.Context, cmd mdm.CheckinCommand) error {
+			received = cmd
+			return nil
+		},
+	}
+	h := checkin.MakeHTTPHandlersWithConfig(
+		context.Background(),
+		checkin.Endpoints{CheckinEndpoint: checkin.MakeCheckinEndpoint(svc)},
+		checkin.HTTPConfig{Methods: []string{"PUT"}, ContentType: checkin.CheckinContentType},
+	)
+	var signature string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(mdmsig.Header)
+		body, _ = ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.CheckinHandler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	t.Run("signed", func(t *testing.T) {
+		cert, key, err := mdmsig.NewIdentity("some-device")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := checkin.NewHTTPClient(srv.URL, checkin.WithSigner(cert, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := mustLoadCommand(t, "TokenUpdate")
+		if err := client.TokenUpdate(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(received, cmd) {
+			t.Errorf("\nwant: %#v\nhave: %#v", cmd, received)
+		}
+
+		der, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := pkcs7.Parse(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7.Content = body
+		if err := p7.Verify(); err != nil {
+			t.Errorf("verify signature: %s", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		client, err := checkin.NewHTTPClient(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.AuthenticateFunc = mock.FailCheckin
+		svc.CheckoutFunc = func(context.Context, mdm.CheckinCommand) error {
+			return &checkin.Error{
+				Kind:       checkin.KindStorageUnavailable,
+				Err:        errors.New("disk full"),
+				RetryAfter: 3 * time.Second,
+			}
+		}
+		tests := []struct {
+			name       string
+			checkin    func(context.Context, mdm.CheckinCommand) error
+			cmd        mdm.CheckinCommand
+			wantKind   checkin.Kind
+			retryAfter time.Duration
+		}{
+			{"unauthorized", client.Authenticate, mustLoadCommand(t, "Authenticate"), checkin.KindUnauthorized, 0},
+			{"unavailable", client.CheckOut, mustLoadCommand(t, "CheckOut"), checkin.KindUpstreamUnavailable, 3 * time.Second},
+			{"unsupported_message_type", client.CheckOut, mdm.CheckinCommand{MessageType: "Unknown"}, checkin.KindUnsupportedMessageType, 0},
+		}
+		for _, tt := range tests {
+			err := tt.checkin(ctx, tt.cmd)
+			e, ok := err.(*checkin.Error)
+			if !ok {
+				t.Errorf("%s: want *checkin.Error, have %#v", tt.name, err)
+				continue
+			}
+			if e.Kind != tt.wantKind || e.RetryAfter != tt.retryAfter {
+				t.Errorf("%s: want %s retry after %s, have %s retry after %s",
+					tt.name, tt.wantKind, tt.retryAfter, e.Kind, e.RetryAfter)
+			}
+		}
+	})
+
+	t.Run("connection_failed", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		client, err := checkin.NewHTTPClient(closed.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.CheckOut(ctx, mustLoadCommand(t, "CheckOut"))
+		if kind, ok := checkin.ErrorKind(err); !ok || kind != checkin.KindUpstreamUnavailable {
+			t.Errorf("want upstream_unavailable error, have %v", err)
+		}
+	})
+}
+
+func TestHTTPClient_mutualTLS(t *testing.T) {
+	var peerCerts int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCerts = len(r.TLS.PeerCertificates)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	cert, key, err := mdmsig.NewIdentity("some-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := checkin.NewHTTPClient(srv.URL, checkin.WithTLSConfig(&tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		InsecureSkipVerify: true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CheckOut(context.Background(), mustLoadCommand(t, "CheckOut")); err != nil {
+		t.Fatal(err)
+	}
+	if peerCerts != 1 {
+		t.Errorf("want client certificate, have %d peer certificates", peerCerts)
+	}
+
+	if _, err := checkin.NewHTTPClient(srv.URL, checkin.WithTLSConfig(&tls.Config{}), checkin.WithHTTPClient(http.DefaultClient)); err == nil {
+		t.Error("want error combining WithTLSConfig and WithHTTPClient")
+	}
+}
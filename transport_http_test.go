@@ -0,0 +1,129 @@
+package checkin
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/micromdm/checkin/service/mock"
+	"golang.org/x/net/context"
+)
+
+func TestMakeHTTPHandlersWithConfig(t *testing.T) {
+	rejected := make(reasonCounter)
+	config := HTTPConfig{
+		Methods:     []string{"PUT"},
+		ContentType: CheckinContentType,
+		MaxBodySize: 1000,
+		Rejected:    rejected,
+	}
+	svc := &mock.CheckinService{AuthenticateFunc: mock.SucceedCheckin}
+	h := MakeHTTPHandlersWithConfig(
+		context.Background(),
+		Endpoints{CheckinEndpoint: MakeCheckinEndpoint(svc)},
+		config,
+	)
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        io.Reader
+		wantStatus  int
+		wantReason  string
+	}{
+		{
+			name:        "happy_path",
+			method:      "PUT",
+			contentType: CheckinContentType,
+			body:        mustMarshalCheckinRequest(t, "Authenticate"),
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "content_type_params",
+			method:      "PUT",
+			contentType: CheckinContentType + "; charset=utf-8",
+			body:        mustMarshalCheckinRequest(t, "Authenticate"),
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "method",
+			method:      "POST",
+			contentType: CheckinContentType,
+			body:        mustMarshalCheckinRequest(t, "Authenticate"),
+			wantStatus:  http.StatusMethodNotAllowed,
+			wantReason:  "method",
+		},
+		{
+			name:        "content_type",
+			method:      "PUT",
+			contentType: "application/json",
+			body:        mustMarshalCheckinRequest(t, "Authenticate"),
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantReason:  "content_type",
+		},
+		{
+			name:        "content_length",
+			method:      "PUT",
+			contentType: CheckinContentType,
+			body:        strings.NewReader(strings.Repeat("a", 1001)),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantReason:  "body_size",
+		},
+		{
+			name:        "chunked_body",
+			method:      "PUT",
+			contentType: CheckinContentType,
+			body:        neverEnding('a'),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantReason:  "body_size",
+		},
+		{
+			name:        "invalid_plist",
+			method:      "PUT",
+			contentType: CheckinContentType,
+			body:        bytes.NewBufferString("not a plist"),
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/checkin", tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			before := rejected[tt.wantReason]
+			h.CheckinHandler.ServeHTTP(rec, req)
+			if want, have := tt.wantStatus, rec.Code; want != have {
+				t.Errorf("want status %d, have %d", want, have)
+			}
+			if tt.wantReason != "" && rejected[tt.wantReason] != before+1 {
+				t.Errorf("rejection with reason %q not counted", tt.wantReason)
+			}
+			if tt.wantStatus == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "PUT" {
+				t.Errorf("want Allow header PUT, have %q", rec.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+// reasonCounter counts by the value of the "reason" label.
+type reasonCounter map[string]float64
+
+func (c reasonCounter) With(labelValues ...string) metrics.Counter {
+	return reasonCounterValue{c, labelValues[1]}
+}
+
+func (c reasonCounter) Add(delta float64) {}
+
+type reasonCounterValue struct {
+	c      reasonCounter
+	reason string
+}
+
+func (v reasonCounterValue) With(labelValues ...string) metrics.Counter { return v }
+
+func (v reasonCounterValue) Add(delta float64) { v.c[v.reason] += delta }
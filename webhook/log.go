@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// DeliveryBucket is the *bolt.DB bucket where the outcome of every delivery
+// is logged, oldest first. Old deliveries are deleted with Prune.
+const DeliveryBucket = "mdm.Checkin.WEBHOOK_DELIVERIES"
+
+// ErrStopIteration can be returned by the function passed to ForEachDelivery
+// to stop iterating without causing ForEachDelivery to return an error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// Delivery is the outcome of delivering an event to an endpoint.
+type Delivery struct {
+	EventID     string
+	Topic       string
+	MessageType string
+	UDID        string
+	Endpoint    string
+
+	// Attempts is the number of requests sent. It is zero for deliveries
+	// dropped because the queue of the endpoint was full.
+	Attempts int
+
+	// StatusCode is the status of the last response, or zero if there was
+	// none.
+	StatusCode int
+
+	// Error is empty if the event was delivered, and the reason of the
+	// last failed attempt otherwise.
+	Error string
+
+	// Time is when the delivery finished.
+	Time time.Time
+}
+
+// Delivered reports whether the endpoint accepted the event.
+func (d *Delivery) Delivered() bool {
+	return d.Error == ""
+}
+
+// putDelivery appends a delivery to the log. Keys are the decimal timestamp
+// of the delivery, followed by the event ID and endpoint, so that they sort
+// chronologically and don't collide.
+func putDelivery(db *bolt.DB, d *Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%d/%s/%s", d.Time.UnixNano(), d.EventID, d.Endpoint)
+	return db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(DeliveryBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", DeliveryBucket)
+		}
+		return bkt.Put([]byte(key), data)
+	})
+}
+
+// ForEachDelivery calls fn for every logged delivery, oldest first.
+// Iteration stops at the first error returned by fn, which is returned by
+// ForEachDelivery unless it is ErrStopIteration.
+func ForEachDelivery(db *bolt.DB, fn func(*Delivery) error) error {
+	err := db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(DeliveryBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", DeliveryBucket)
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return fmt.Errorf("delivery %s: %s", k, err)
+			}
+			return fn(&d)
+		})
+	})
+	if err == ErrStopIteration {
+		return nil
+	}
+	return err
+}
+
+// Deliveries returns the logged deliveries, oldest first.
+func (d *Dispatcher) Deliveries() ([]Delivery, error) {
+	var deliveries []Delivery
+	err := ForEachDelivery(d.config.DB, func(delivery *Delivery) error {
+		deliveries = append(deliveries, *delivery)
+		return nil
+	})
+	return deliveries, err
+}
+
+// Prune deletes the deliveries logged before the given time, returning the
+// number deleted.
+func (d *Dispatcher) Prune(before time.Time) (int, error) {
+	return pruneDeliveries(d.config.DB, before)
+}
+
+func pruneDeliveries(db *bolt.DB, before time.Time) (int, error) {
+	var n int
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(DeliveryBucket))
+		if bkt == nil {
+			return fmt.Errorf("bucket %q not found!", DeliveryBucket)
+		}
+		// keys are collected first, because deleting while iterating
+		// with a cursor skips keys. Keys sort chronologically, so the
+		// iteration stops at the first delivery to keep.
+		var old [][]byte
+		c := bkt.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			i := strings.IndexByte(string(k), '/')
+			if i < 0 {
+				return fmt.Errorf("delivery %s: invalid key", k)
+			}
+			nano, err := strconv.ParseInt(string(k[:i]), 10, 64)
+			if err != nil {
+				return fmt.Errorf("delivery %s: invalid key: %s", k, err)
+			}
+			if !time.Unix(0, nano).Before(before) {
+				break
+			}
+			old = append(old, k)
+		}
+		for _, k := range old {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(old)
+		return nil
+	})
+	return n, err
+}
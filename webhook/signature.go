@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of a webhook request.
+const (
+	// EventIDHeader is the ID of the event, so that endpoints can ignore
+	// retried deliveries which they already processed.
+	EventIDHeader = "X-Checkin-Event-Id"
+
+	// TimestampHeader is the time the request was signed, in seconds since
+	// the Unix epoch.
+	TimestampHeader = "X-Checkin-Timestamp"
+
+	// SignatureHeader is the signature returned by Sign.
+	SignatureHeader = "X-Checkin-Signature"
+)
+
+// ErrInvalidSignature is returned by Verify for requests which are not
+// signed with the secret, or were signed too long ago.
+var ErrInvalidSignature = errors.New("webhook: invalid signature")
+
+// Sign returns the signature of a request body sent at timestamp, which is
+// "sha256=" followed by the hex encoded HMAC-SHA256, keyed with secret, of
+// the decimal timestamp, a period, and the body.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a webhook request with the given headers
+// and body. Requests signed more than maxAge ago, or in the future, are
+// rejected to limit replays. A zero maxAge accepts any timestamp.
+func Verify(secret []byte, header http.Header, body []byte, maxAge time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if maxAge > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > maxAge || age < -maxAge {
+			return ErrInvalidSignature
+		}
+	}
+	want := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(want), []byte(header.Get(SignatureHeader))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+// Package webhook delivers check-in events to HTTP endpoints, for systems
+// which can't consume the message queue. A Dispatcher is a simple.Publisher:
+// pass it to simple.WithPublisher.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/micromdm/checkin"
+)
+
+// DefaultQueueSize is the default number of deliveries queued for each
+// endpoint.
+const DefaultQueueSize = 100
+
+// ErrClosed is returned by Publish once the Dispatcher is closed.
+var ErrClosed = errors.New("webhook: dispatcher closed")
+
+// errQueueFull is recorded for deliveries dropped because the queue of the
+// endpoint is full, and returned by Publish.
+var errQueueFull = errors.New("queue full")
+
+// Endpoint is a URL which events are POSTed to.
+type Endpoint struct {
+	// Name identifies the endpoint in the delivery log and in metrics.
+	// Defaults to the URL.
+	Name string
+
+	URL string
+
+	// Secret is the key of the HMAC-SHA256 signature of every request.
+	// Requests are not signed if it is empty. See Verify.
+	Secret []byte
+
+	// MessageTypes are the message types delivered to the endpoint, such
+	// as "Authenticate" and "CheckOut". Every event is delivered if empty.
+	MessageTypes []string
+}
+
+func (e Endpoint) match(messageType string) bool {
+	if len(e.MessageTypes) == 0 {
+		return true
+	}
+	for _, t := range e.MessageTypes {
+		if t == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy configures how failed deliveries are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a delivery is attempted,
+	// including the first attempt.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It doubles
+	// after every failed attempt, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy retries a delivery for about a minute.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    8,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// Config configures a Dispatcher.
+type Config struct {
+	Endpoints []Endpoint
+
+	// DB stores the delivery log in DeliveryBucket. Required.
+	DB *bolt.DB
+
+	// Client sends the requests. Defaults to an *http.Client with a 10
+	// second timeout.
+	Client *http.Client
+
+	// Retry defaults to DefaultRetryPolicy.
+	Retry RetryPolicy
+
+	// QueueSize is the number of deliveries queued for each endpoint.
+	// Deliveries to an endpoint whose queue is full are dropped, logged
+	// as failed, and fail the Publish. Defaults to DefaultQueueSize.
+	QueueSize int
+
+	// Logger logs failed deliveries. Optional.
+	Logger log.Logger
+
+	// Deliveries counts deliveries, labeled by "endpoint" and "result",
+	// which is "delivered", "failed" or "dropped". Optional.
+	Deliveries metrics.Counter
+}
+
+// Dispatcher POSTs events to webhook endpoints. Each endpoint has a queue,
+// which is delivered in order by a single worker, so that a slow or failing
+// endpoint delays neither check-ins nor the other endpoints.
+type Dispatcher struct {
+	config  Config
+	workers []*worker
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewDispatcher creates a Dispatcher and starts its workers.
+func NewDispatcher(config Config) (*Dispatcher, error) {
+	if config.DB == nil {
+		return nil, errors.New("webhook: a *bolt.DB is required for the delivery log")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.Retry.MaxAttempts <= 0 {
+		config.Retry = DefaultRetryPolicy
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.Logger == nil {
+		config.Logger = log.NewNopLogger()
+	}
+	if config.Deliveries == nil {
+		config.Deliveries = discard.NewCounter()
+	}
+	d := &Dispatcher{config: config}
+	names := make(map[string]bool)
+	for _, e := range config.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil, fmt.Errorf("webhook: endpoint %q: %s", e.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("webhook: endpoint %q: not an http or https URL", e.URL)
+		}
+		if e.Name == "" {
+			e.Name = e.URL
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("webhook: duplicate endpoint name %q", e.Name)
+		}
+		names[e.Name] = true
+		d.workers = append(d.workers, &worker{
+			endpoint: e,
+			queue:    make(chan *delivery, config.QueueSize),
+		})
+	}
+	err := config.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(DeliveryBucket))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %s", err)
+	}
+	for _, w := range d.workers {
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d, nil
+}
+
+// Publish queues the event for delivery to every endpoint which accepts its
+// message type. It does not wait for the deliveries. An event published to
+// several topics is delivered once for each.
+//
+// Publish fails if the queue of an endpoint is full, so that the publish can
+// be retried or the event dead-lettered, for example with simple.WithRetry.
+// The endpoints which queued the event receive it again if the publish is
+// retried; they can recognize it by its EventIDHeader.
+func (d *Dispatcher) Publish(topic string, body []byte) error {
+	event := new(checkin.Event)
+	if err := checkin.UnmarshalEvent(body, event); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(makePayload(topic, event))
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+	var full []string
+	for _, w := range d.workers {
+		if !w.endpoint.match(event.Command.MessageType) {
+			continue
+		}
+		dl := &delivery{topic: topic, event: event, payload: payload}
+		select {
+		case w.queue <- dl:
+		default:
+			d.finish(w.endpoint, dl, 0, 0, errQueueFull)
+			full = append(full, w.endpoint.Name)
+		}
+	}
+	if len(full) > 0 {
+		return fmt.Errorf("webhook: endpoints %s: %s", strings.Join(full, ", "), errQueueFull)
+	}
+	return nil
+}
+
+// Close stops accepting events, and returns once the queued deliveries have
+// been attempted.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, w := range d.workers {
+			close(w.queue)
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// worker delivers the queue of an endpoint.
+type worker struct {
+	endpoint Endpoint
+	queue    chan *delivery
+}
+
+// delivery is a queued event.
+type delivery struct {
+	topic   string
+	event   *checkin.Event
+	payload []byte
+}
+
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for dl := range w.queue {
+		d.deliver(w.endpoint, dl)
+	}
+}
+
+// deliver POSTs the delivery to the endpoint, retrying according to the
+// retry policy, and logs the outcome.
+func (d *Dispatcher) deliver(e Endpoint, dl *delivery) {
+	policy := d.config.Retry
+	backoff := policy.InitialBackoff
+	var (
+		attempt int
+		status  int
+		err     error
+	)
+	for attempt = 1; ; attempt++ {
+		var retry bool
+		status, retry, err = d.post(e, dl)
+		if err == nil || !retry || attempt >= policy.MaxAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+	d.finish(e, dl, attempt, status, err)
+}
+
+// post sends a single request. It returns the response status, and whether a
+// failed request may succeed if retried.
+func (d *Dispatcher) post(e Endpoint, dl *delivery) (status int, retry bool, err error) {
+	req, err := http.NewRequest("POST", e.URL, bytes.NewReader(dl.payload))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, dl.event.ID)
+	if len(e.Secret) > 0 {
+		timestamp := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, Sign(e.Secret, timestamp, dl.payload))
+	}
+	resp, err := d.config.Client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return resp.StatusCode, retry, fmt.Errorf("endpoint responded with status %s", resp.Status)
+}
+
+// finish logs the outcome of a delivery.
+func (d *Dispatcher) finish(e Endpoint, dl *delivery, attempts, status int, err error) {
+	result := "delivered"
+	switch {
+	case err == errQueueFull:
+		result = "dropped"
+	case err != nil:
+		result = "failed"
+	}
+	d.config.Deliveries.With("endpoint", e.Name, "result", result).Add(1)
+	entry := &Delivery{
+		EventID:     dl.event.ID,
+		Topic:       dl.topic,
+		MessageType: dl.event.Command.MessageType,
+		UDID:        dl.event.Command.UDID,
+		Endpoint:    e.Name,
+		Attempts:    attempts,
+		StatusCode:  status,
+		Time:        time.Now().UTC(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		d.config.Logger.Log("msg", "webhook delivery failed", "endpoint", e.Name, "event_id", dl.event.ID, "attempts", attempts, "err", err)
+	}
+	if err := putDelivery(d.config.DB, entry); err != nil {
+		d.config.Logger.Log("msg", "log webhook delivery", "endpoint", e.Name, "event_id", dl.event.ID, "err", err)
+	}
+}
+
+// Payload is the JSON body POSTed to endpoints.
+type Payload struct {
+	// Topic is the topic the event was published to.
+	Topic string `json:"topic"`
+	Event Event  `json:"event"`
+}
+
+// Event is a check-in event. Secrets sent by the device, such as the push
+// token and the UnlockToken, are left out.
+type Event struct {
+	ID                    string    `json:"id"`
+	Time                  time.Time `json:"time"`
+	UDID                  string    `json:"udid"`
+	MessageType           string    `json:"message_type"`
+	PushTopic             string    `json:"push_topic,omitempty"`
+	SerialNumber          string    `json:"serial_number,omitempty"`
+	ProductName           string    `json:"product_name,omitempty"`
+	Model                 string    `json:"model,omitempty"`
+	OSVersion             string    `json:"os_version,omitempty"`
+	BuildVersion          string    `json:"build_version,omitempty"`
+	DeviceName            string    `json:"device_name,omitempty"`
+	IMEI                  string    `json:"imei,omitempty"`
+	MEID                  string    `json:"meid,omitempty"`
+	UserID                string    `json:"user_id,omitempty"`
+	UserShortName         string    `json:"user_short_name,omitempty"`
+	UserLongName          string    `json:"user_long_name,omitempty"`
+	AwaitingConfiguration bool      `json:"awaiting_configuration,omitempty"`
+	Sequence              uint64    `json:"sequence,omitempty"`
+	Duplicate             bool      `json:"duplicate,omitempty"`
+	PolicyRule            string    `json:"policy_rule,omitempty"`
+}
+
+func makePayload(topic string, e *checkin.Event) Payload {
+	cmd := e.Command
+	return Payload{
+		Topic: topic,
+		Event: Event{
+			ID:                    e.ID,
+			Time:                  e.Time,
+			UDID:                  cmd.UDID,
+			MessageType:           cmd.MessageType,
+			PushTopic:             cmd.Topic,
+			SerialNumber:          cmd.SerialNumber,
+			ProductName:           cmd.ProductName,
+			Model:                 cmd.Model,
+			OSVersion:             cmd.OSVersion,
+			BuildVersion:          cmd.BuildVersion,
+			DeviceName:            cmd.DeviceName,
+			IMEI:                  cmd.IMEI,
+			MEID:                  cmd.MEID,
+			UserID:                cmd.UserID,
+			UserShortName:         cmd.UserShortName,
+			UserLongName:          cmd.UserLongName,
+			AwaitingConfiguration: cmd.AwaitingConfiguration,
+			Sequence:              e.Sequence,
+			Duplicate:             e.Duplicate,
+			PolicyRule:            e.PolicyRule,
+		},
+	}
+}
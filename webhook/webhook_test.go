@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/groob/plist"
+	"github.com/micromdm/checkin"
+	"github.com/micromdm/checkin/service/simple"
+	"github.com/micromdm/mdm"
+	"golang.org/x/net/context"
+)
+
+func TestDispatcher(t *testing.T) {
+	secret := []byte("secret")
+	var (
+		mu       sync.Mutex
+		received []Payload
+		failures = 1
+	)
+	inventory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := Verify(secret, r.Header, body, time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get(EventIDHeader) != p.Event.ID {
+			http.Error(w, "event ID mismatch", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, p)
+	}))
+	defer inventory.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	db := openDB(t)
+	d, err := NewDispatcher(Config{
+		DB: db,
+		Endpoints: []Endpoint{
+			{Name: "inventory", URL: inventory.URL, Secret: secret, MessageTypes: []string{"Authenticate", "CheckOut"}},
+			{Name: "rejecting", URL: rejecting.URL},
+		},
+		Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := simple.NewService(db, nil, simple.WithPublisher(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	auth := mustLoadCommand(t, "Authenticate")
+	if err := svc.Authenticate(ctx, auth); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.TokenUpdate(ctx, mustLoadCommand(t, "TokenUpdate")); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	if len(received) != 1 {
+		t.Fatalf("want 1 delivered event, have %d", len(received))
+	}
+	p := received[0]
+	if p.Topic != simple.AuthenticateTopic || p.Event.MessageType != "Authenticate" || p.Event.SerialNumber != auth.SerialNumber {
+		t.Errorf("unexpected payload %+v", p)
+	}
+
+	deliveries, err := d.Deliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Delivery{
+		"inventory/Authenticate": {Attempts: 2, StatusCode: 200},
+		"rejecting/Authenticate": {Attempts: 1, StatusCode: 400},
+		"rejecting/TokenUpdate":  {Attempts: 1, StatusCode: 400},
+	}
+	if len(deliveries) != len(want) {
+		t.Fatalf("want %d logged deliveries, have %d: %+v", len(want), len(deliveries), deliveries)
+	}
+	for _, have := range deliveries {
+		key := have.Endpoint + "/" + have.MessageType
+		w, ok := want[key]
+		if !ok {
+			t.Errorf("unexpected delivery %+v", have)
+			continue
+		}
+		if have.Attempts != w.Attempts || have.StatusCode != w.StatusCode {
+			t.Errorf("%s: want %d attempts with status %d, have %d with status %d", key, w.Attempts, w.StatusCode, have.Attempts, have.StatusCode)
+		}
+		if delivered := have.StatusCode == 200; have.Delivered() != delivered {
+			t.Errorf("%s: want delivered %v, have error %q", key, delivered, have.Error)
+		}
+	}
+
+	if err := d.Publish(simple.AuthenticateTopic, nil); err != ErrClosed {
+		t.Errorf("publish after close: want ErrClosed, have %v", err)
+	}
+}
+
+func TestDispatcher_queueFull(t *testing.T) {
+	release := make(chan struct{})
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer blocking.Close()
+
+	db := openDB(t)
+	d, err := NewDispatcher(Config{
+		DB:        db,
+		Endpoints: []Endpoint{{Name: "blocking", URL: blocking.URL}},
+		QueueSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := checkin.MarshalEvent(checkin.NewEvent(mustLoadCommand(t, "Authenticate")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the worker holds one delivery and the queue another, so one of the
+	// first three publishes fails.
+	var failed bool
+	for i := 0; i < 3 && !failed; i++ {
+		failed = d.Publish(simple.AuthenticateTopic, body) != nil
+	}
+	close(release)
+	d.Close()
+	if !failed {
+		t.Fatal("want publish to a full queue to fail")
+	}
+
+	deliveries, err := d.Deliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dropped int
+	for _, delivery := range deliveries {
+		if delivery.Attempts == 0 {
+			dropped++
+		}
+	}
+	if dropped != 1 {
+		t.Errorf("want 1 dropped delivery logged, have %d", dropped)
+	}
+}
+
+func TestDispatcher_Prune(t *testing.T) {
+	db := openDB(t)
+	d, err := NewDispatcher(Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	now := time.Now().UTC()
+	for i, age := range []time.Duration{48 * time.Hour, 25 * time.Hour, time.Hour} {
+		delivery := &Delivery{EventID: strconv.Itoa(i), Endpoint: "inventory", Time: now.Add(-age)}
+		if err := putDelivery(db, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := d.Prune(now.Add(-24 * time.Hour)); err != nil || n != 2 {
+		t.Errorf("Prune = %d, %v, want 2 deliveries", n, err)
+	}
+	deliveries, err := d.Deliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].EventID != "2" {
+		t.Errorf("want the latest delivery kept, have %+v", deliveries)
+	}
+}
+
+func TestNewDispatcher_invalid(t *testing.T) {
+	db := openDB(t)
+	for name, endpoints := range map[string][]Endpoint{
+		"scheme":    {{URL: "ftp://example.com"}},
+		"duplicate": {{URL: "http://a.example.com", Name: "a"}, {URL: "http://b.example.com", Name: "a"}},
+	} {
+		if _, err := NewDispatcher(Config{DB: db, Endpoints: endpoints}); err == nil {
+			t.Errorf("%s: want error, have nil", name)
+		}
+	}
+	if _, err := NewDispatcher(Config{}); err == nil {
+		t.Error("no db: want error, have nil")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, body := []byte("secret"), []byte(`{"topic":"mdm.Authenticate"}`)
+	sign := func(timestamp int64) http.Header {
+		h := make(http.Header)
+		h.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		h.Set(SignatureHeader, Sign(secret, timestamp, body))
+		return h
+	}
+	now := time.Now().Unix()
+	if err := Verify(secret, sign(now), body, time.Minute); err != nil {
+		t.Errorf("valid signature: %s", err)
+	}
+	if err := Verify([]byte("other"), sign(now), body, time.Minute); err != ErrInvalidSignature {
+		t.Errorf("wrong secret: want ErrInvalidSignature, have %v", err)
+	}
+	if err := Verify(secret, sign(now), append(body, ' '), time.Minute); err != ErrInvalidSignature {
+		t.Errorf("modified body: want ErrInvalidSignature, have %v", err)
+	}
+	if err := Verify(secret, sign(now-3600), body, time.Minute); err != ErrInvalidSignature {
+		t.Errorf("old timestamp: want ErrInvalidSignature, have %v", err)
+	}
+}
+
+func openDB(t *testing.T) *bolt.DB {
+	f, _ := ioutil.TempFile("", "bolt-")
+	f.Close()
+	os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0777, nil)
+	if err != nil {
+		t.Fatalf("couldn't open bolt, err %s\n", err)
+	}
+	return db
+}
+
+func mustLoadCommand(t *testing.T, name string) mdm.CheckinCommand {
+	var payload mdm.CheckinCommand
+	data, err := ioutil.ReadFile("../testdata/" + name + ".plist")
+	if err != nil {
+		t.Fatalf("failed to open test file %q.plist, err: %s", name, err)
+	}
+	if err := plist.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("failed to unmarshal plist %q, err: %s", name, err)
+	}
+	return payload
+}